@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+func (sf *StarFleet) handleAdminWorkers(w http.ResponseWriter, r *http.Request) {
+	id := r.Header.Get("X-Request-ID")
+
+	switch r.Method {
+	case "GET":
+		writeJson(w, id, http.StatusOK, sf.workerPool.Stats())
+	case "POST":
+		var config WorkerConfig
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+			LogHttpErr(w, id, "Invalid worker config", err, http.StatusBadRequest)
+			return
+		}
+
+		// Launching processes is reserved for the config file, so a leaked
+		// admin token cannot run arbitrary commands on the host.
+		if config.Command != "" || config.Args != nil || config.Env != nil || config.Workdir != "" {
+			LogHttpErr(w, id, "Process fields cannot be set at runtime", nil, http.StatusBadRequest)
+			return
+		}
+
+		worker, err := sf.workerPool.Add(config)
+		if errors.Is(err, errWorkerExists) {
+			LogHttpErr(w, id, err.Error(), err, http.StatusConflict)
+			return
+		} else if err != nil {
+			LogHttpErr(w, id, err.Error(), err, http.StatusBadRequest)
+			return
+		}
+
+		writeJson(w, id, http.StatusCreated, worker.Stats())
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (sf *StarFleet) handleAdminWorker(w http.ResponseWriter, r *http.Request) {
+	id := r.Header.Get("X-Request-ID")
+	workerId := strings.TrimPrefix(r.URL.Path, "/admin/workers/")
+
+	if workerId, ok := strings.CutSuffix(workerId, "/drain"); ok {
+		sf.handleAdminDrain(w, r, workerId)
+		return
+	}
+
+	var err error
+	switch r.Method {
+	case "GET":
+		worker := sf.workerPool.Get(workerId)
+		if worker == nil {
+			LogHttpErr(w, id, "Unknown worker", nil, http.StatusNotFound)
+			return
+		}
+		writeJson(w, id, http.StatusOK, worker.Stats())
+		return
+	case "PATCH":
+		var update WorkerUpdate
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			LogHttpErr(w, id, "Invalid worker update", err, http.StatusBadRequest)
+			return
+		}
+		err = sf.workerPool.Update(workerId, update)
+	case "DELETE":
+		err = sf.workerPool.Remove(workerId)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if errors.Is(err, errUnknownWorker) {
+		LogHttpErr(w, id, "Unknown worker", err, http.StatusNotFound)
+		return
+	} else if err != nil {
+		LogHttpErr(w, id, err.Error(), err, http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAdminDrain puts a worker into draining. With ?wait=true it responds
+// once the worker is idle, otherwise straight away with its current stats.
+func (sf *StarFleet) handleAdminDrain(w http.ResponseWriter, r *http.Request, workerId string) {
+	id := r.Header.Get("X-Request-ID")
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Keep hold of the worker, it may be removed while we wait.
+	worker := sf.workerPool.Get(workerId)
+	if worker == nil {
+		LogHttpErr(w, id, "Unknown worker", nil, http.StatusNotFound)
+		return
+	}
+	idle := worker.Drain()
+
+	if r.URL.Query().Get("wait") == "true" {
+		select {
+		case <-idle:
+		case <-worker.done:
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	writeJson(w, id, http.StatusAccepted, worker.Stats())
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func adminRequest(t *testing.T, sf *StarFleet, method string, path string, body string, token string) *httptest.ResponseRecorder {
+	handler := sf.admin.Middleware(http.HandlerFunc(sf.handleAdminWorkers))
+	if path != "/admin/workers" {
+		handler = sf.admin.Middleware(http.HandlerFunc(sf.handleAdminWorker))
+	}
+
+	r := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	r.Header.Set("Authorization", token)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestAdminWorkers(t *testing.T) {
+	sim := simulateWorker()
+	defer sim.Close()
+
+	sf := New(StarFleetConfig{
+		Admin: &AuthConfig{JwtSecretKey: "secret", RolePath: []string{"admin"}},
+		WorkerPoolConfig: WorkerPoolConfig{
+			Workers: []WorkerConfig{{Host: sim.URL, Capacity: 1}},
+		},
+	})
+	sf.workerPool.Run()
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"admin": true}).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	userToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"admin": false}).SignedString([]byte("secret"))
+
+	if res := adminRequest(t, sf, "GET", "/admin/workers", "", userToken); res.Code != http.StatusUnauthorized {
+		t.Fatalf("expected non-admins to be rejected, got %d", res.Code)
+	}
+
+	body := fmt.Sprintf(`{"id":"gpu-1","host":"%s","capacity":2,"headers":{"X-Key":"a"}}`, sim.URL)
+	if res := adminRequest(t, sf, "POST", "/admin/workers", body, token); res.Code != http.StatusCreated {
+		t.Fatalf("expected worker to be created, got %d: %s", res.Code, res.Body)
+	}
+	if res := adminRequest(t, sf, "POST", "/admin/workers", body, token); res.Code != http.StatusConflict {
+		t.Fatalf("expected duplicate id to conflict, got %d", res.Code)
+	}
+	if res := adminRequest(t, sf, "POST", "/admin/workers", `{"host":"http://x","capacity":1,"backend":"nope"}`, token); res.Code != http.StatusBadRequest {
+		t.Fatalf("expected invalid config to be rejected, got %d", res.Code)
+	}
+	if res := adminRequest(t, sf, "POST", "/admin/workers", `{"id":"sh","host":"http://x","capacity":1,"command":"sh","args":["-c","id"]}`, token); res.Code != http.StatusBadRequest {
+		t.Fatalf("expected process fields to be rejected, got %d", res.Code)
+	}
+	if sf.workerPool.Get("sh") != nil {
+		t.Fatal("expected no worker to be added with a command")
+	}
+
+	if res := adminRequest(t, sf, "PATCH", "/admin/workers/gpu-1", `{"capacity":4,"headers":{"X-Key":"b"}}`, token); res.Code != http.StatusNoContent {
+		t.Fatalf("expected worker to be updated, got %d: %s", res.Code, res.Body)
+	}
+	worker := sf.workerPool.Get("gpu-1")
+	if worker.Stats().Capacity != 4 || worker.getHeaders()["X-Key"] != "b" {
+		t.Fatalf("update was not applied: %+v", worker.Stats())
+	}
+
+	res := adminRequest(t, sf, "GET", "/admin/workers", "", token)
+	var stats WorkerPoolStats
+	if err := json.NewDecoder(res.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 2 || stats[0].Id != "0" || stats[1].Id != "gpu-1" {
+		t.Fatalf("unexpected workers %+v", stats)
+	}
+	if stats[1].Host != sim.URL {
+		t.Fatalf("expected the worker's host to be listed, got %q", stats[1].Host)
+	}
+
+	if res := adminRequest(t, sf, "POST", "/admin/workers/gpu-1/drain?wait=true", "", token); res.Code != http.StatusAccepted {
+		t.Fatalf("expected worker to drain, got %d", res.Code)
+	}
+	if !sf.workerPool.Get("gpu-1").Stats().Drained {
+		t.Fatal("expected idle worker to report drained")
+	}
+	if res := adminRequest(t, sf, "POST", "/admin/workers/nope/drain", "", token); res.Code != http.StatusNotFound {
+		t.Fatalf("expected unknown worker, got %d", res.Code)
+	}
+
+	if res := adminRequest(t, sf, "DELETE", "/admin/workers/0", "", token); res.Code != http.StatusNoContent {
+		t.Fatalf("expected worker to be removed, got %d", res.Code)
+	}
+	if res := adminRequest(t, sf, "DELETE", "/admin/workers/0", "", token); res.Code != http.StatusNotFound {
+		t.Fatalf("expected unknown worker, got %d", res.Code)
+	}
+	if sf.workerPool.Get("0") != nil || len(sf.workerPool.Workers()) != 1 {
+		t.Fatal("expected worker 0 to be gone")
+	}
+}
+
+func TestAdminDrainRemoved(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	sim := simulateBlockingWorker(release)
+	defer sim.Close()
+
+	sf := New(StarFleetConfig{
+		Admin: &AuthConfig{JwtSecretKey: "secret", RolePath: []string{"admin"}},
+		WorkerPoolConfig: WorkerPoolConfig{
+			Workers: []WorkerConfig{{Id: "busy", Host: sim.URL, Capacity: 1, FirstTokenTimeout: -1}},
+		},
+	})
+	sf.workerPool.Run()
+	token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"admin": true}).SignedString([]byte("secret"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := sf.workerPool.Enlist(NewJob(ctx, "running", nil)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, func() bool { return sf.workerPool.Get("busy").Stats().Running == 1 })
+
+	// Removing the worker while a drain waits for it must not crash the
+	// waiting request.
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- adminRequest(t, sf, "POST", "/admin/workers/busy/drain?wait=true", "", token)
+	}()
+	waitFor(t, 5*time.Second, func() bool { return sf.workerPool.Get("busy").Draining() })
+	if err := sf.workerPool.Remove("busy"); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case res := <-done:
+		if res.Code != http.StatusAccepted {
+			t.Fatalf("expected the drain to return, got %d", res.Code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("drain kept waiting for a removed worker")
+	}
+}
+
+func TestDashboardDrainRequiresAdmin(t *testing.T) {
+	sim := simulateWorker()
+	defer sim.Close()
+
+	sf := New(StarFleetConfig{
+		Admin: &AuthConfig{JwtSecretKey: "secret", RolePath: []string{"admin"}},
+		WorkerPoolConfig: WorkerPoolConfig{
+			Workers: []WorkerConfig{{Id: "gpu-1", Host: sim.URL, Capacity: 1}},
+		},
+	})
+	sf.workerPool.Run()
+	token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"admin": true}).SignedString([]byte("secret"))
+
+	drain := func(method string, token string) int {
+		r := httptest.NewRequest(method, "/dashboard-drain/gpu-1", nil)
+		r.Header.Set("Authorization", token)
+		w := httptest.NewRecorder()
+		sf.admin.Middleware(http.HandlerFunc(sf.handleDashboardDrain)).ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := drain("POST", ""); code != http.StatusUnauthorized {
+		t.Fatalf("expected anonymous drain to be rejected, got %d", code)
+	}
+	if code := drain("GET", token); code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected GET to be rejected, got %d", code)
+	}
+	if sf.workerPool.Get("gpu-1").Draining() {
+		t.Fatal("expected rejected requests not to drain the worker")
+	}
+	if code := drain("POST", token); code != http.StatusOK {
+		t.Fatalf("expected worker to drain, got %d", code)
+	}
+	if !sf.workerPool.Get("gpu-1").Draining() {
+		t.Fatal("expected worker to be draining")
+	}
+}
+
+func TestWorkerPoolConcurrentModification(t *testing.T) {
+	sim := simulateWorker()
+	defer sim.Close()
+
+	workerPool := NewWorkerPool(WorkerPoolConfig{})
+	workerPool.Run()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				id := fmt.Sprintf("%d-%d", i, j)
+				if _, err := workerPool.Add(WorkerConfig{Id: id, Host: sim.URL, Capacity: 1}); err != nil {
+					t.Error(err)
+					return
+				}
+				if j%2 == 0 {
+					workerPool.Remove(id)
+				}
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				workerPool.getWorker(nil)
+				workerPool.Stats()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(workerPool.Workers()) != 80 {
+		t.Fatalf("expected 80 workers, got %d", len(workerPool.Workers()))
+	}
+}
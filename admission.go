@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// AdmissionConfig bounds the pool queue. MaxQueueDepth caps the number of
+// jobs waiting across the pool, and MaxWait is how many milliseconds a job
+// may wait for its first worker before it is turned away. Workers can also
+// set their own MaxQueueDepth, which caps the jobs waiting for them.
+type AdmissionConfig struct {
+	MaxQueueDepth int `json:"maxQueueDepth,omitempty"`
+	MaxWait       int `json:"maxWait,omitempty"`
+}
+
+var (
+	errQueueFull    = errors.New("queue is full")
+	errQueueTimeout = errors.New("timed out waiting in queue")
+)
+
+const (
+	throughputWindow   = time.Minute
+	throughputInterval = time.Second
+)
+
+// admit checks whether job may join the pool queue. A worker's queue depth
+// counts every waiting job it could run, and the job is only turned away if
+// all the workers that could run it are full. Jobs about to take a free slot
+// do not count as waiting. Callers hold wp.pending.mu.
+func (wp *WorkerPool) admit(job *Job) error {
+	free, limit := 0, 0
+	var workers []*Worker
+	for _, worker := range wp.Workers() {
+		if !worker.Available() {
+			continue
+		}
+		slots := 0
+		if worker.hasSlot() {
+			slots = worker.queue.Capacity() - worker.Outstanding()
+		}
+		free += slots
+		if !wp.eligible(worker, job) {
+			continue
+		}
+		if worker.config.MaxQueueDepth <= 0 {
+			limit = -1
+		} else if limit >= 0 {
+			limit += worker.config.MaxQueueDepth + slots
+		}
+		workers = append(workers, worker)
+	}
+
+	depth, total := 0, 0
+	for _, queued := range wp.pending.jobs {
+		if queued.ReqCtx.Err() != nil {
+			continue
+		}
+		total++
+		if limit < 0 {
+			continue
+		}
+		for _, worker := range workers {
+			if wp.eligible(worker, queued) {
+				depth++
+				break
+			}
+		}
+	}
+
+	if depth := wp.admission.MaxQueueDepth; depth > 0 && total >= depth+free {
+		return fmt.Errorf("%w: %d jobs waiting", errQueueFull, total)
+	}
+	if limit >= 0 && depth >= limit {
+		return fmt.Errorf("%w: %d jobs waiting for its workers", errQueueFull, depth)
+	}
+	return nil
+}
+
+// expired reports whether a job has waited longer than MaxWait for its
+// first worker. Jobs that failed over or were handed back by a draining
+// worker have already been admitted.
+func (wp *WorkerPool) expired(job *Job, now time.Time) bool {
+	wait := time.Duration(wp.admission.MaxWait) * time.Millisecond
+	return wait > 0 && !job.bound() && now.Sub(job.start) >= wait
+}
+
+// RetryAfter suggests how long a turned away client should wait before
+// trying again: long enough for the current queue to clear.
+func (wp *WorkerPool) RetryAfter(job *Job) time.Duration {
+	wait := wp.EstimateWait(job, wp.Pending())
+	if wait < time.Second {
+		return time.Second
+	}
+	return time.Duration(math.Ceil(wait.Seconds())) * time.Second
+}
+
+type throughputSample struct {
+	at       time.Time
+	finished int
+}
+
+// throughput tracks how many jobs the pool finished over the last minute.
+type throughput struct {
+	mu      sync.Mutex
+	samples []throughputSample
+}
+
+func (t *throughput) observe(now time.Time, finished int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if n := len(t.samples); n > 0 && now.Sub(t.samples[n-1].at) < throughputInterval {
+		return
+	}
+	t.samples = append(t.samples, throughputSample{now, finished})
+
+	i := 0
+	for i < len(t.samples)-1 && now.Sub(t.samples[i].at) > throughputWindow {
+		i++
+	}
+	t.samples = t.samples[i:]
+}
+
+// rate returns the jobs finished per second, or 0 if it is not known yet.
+func (t *throughput) rate() float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.samples) < 2 {
+		return 0
+	}
+	first, last := t.samples[0], t.samples[len(t.samples)-1]
+	if last.finished <= first.finished {
+		return 0
+	}
+	return float64(last.finished-first.finished) / last.at.Sub(first.at).Seconds()
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAdmissionQueueDepth(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	simA := simulateBlockingWorker(release)
+	defer simA.Close()
+	simB := simulateBlockingWorker(release)
+	defer simB.Close()
+
+	sf := New(StarFleetConfig{
+		WorkerPoolConfig: WorkerPoolConfig{
+			Workers: []WorkerConfig{
+				{Id: "a", Host: simA.URL, Capacity: 1, FirstTokenTimeout: -1, MaxQueueDepth: 2, Models: []string{"small"}},
+				{Id: "b", Host: simB.URL, Capacity: 1, FirstTokenTimeout: -1, Models: []string{"large"}},
+			},
+			Admission: AdmissionConfig{MaxQueueDepth: 4},
+		},
+	})
+	workerPool := sf.workerPool
+	go workerPool.Run()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	enlist := func(id string, model string) error {
+		job := NewJob(ctx, id, nil)
+		job.Model = model
+		return workerPool.Enlist(job)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := enlist(fmt.Sprint("small-", i), "small"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, 5*time.Second, func() bool { return workerPool.Pending() == 2 })
+
+	// Worker a allows two jobs to wait for it, worker b is unbounded.
+	if err := enlist("small-3", "small"); !errors.Is(err, errQueueFull) {
+		t.Fatalf("expected worker a's queue to be full, got %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := enlist(fmt.Sprint("large-", i), "large"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The pool holds at most four waiting jobs.
+	waitFor(t, 5*time.Second, func() bool { return workerPool.Pending() == 4 })
+	if err := enlist("large-3", "large"); !errors.Is(err, errQueueFull) {
+		t.Fatalf("expected the pool queue to be full, got %v", err)
+	}
+
+	r := httptest.NewRequest("POST", "/generate", nil)
+	r.Header.Set("X-Model", "large")
+	w := httptest.NewRecorder()
+	sf.handleGenerate(w, r)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", w.Code)
+	}
+	if retry, err := strconv.Atoi(w.Header().Get("Retry-After")); err != nil || retry < 1 {
+		t.Fatalf("expected a Retry-After header, got %q", w.Header().Get("Retry-After"))
+	}
+	if stats := workerPool.QueueStats(); stats.Rejected != 3 {
+		t.Fatalf("expected 3 rejected jobs, got %+v", stats)
+	}
+}
+
+func TestAdmissionMaxWait(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	sim := simulateBlockingWorker(release)
+	defer sim.Close()
+
+	sf := New(StarFleetConfig{
+		WorkerPoolConfig: WorkerPoolConfig{
+			Workers:   []WorkerConfig{{Host: sim.URL, Capacity: 1, FirstTokenTimeout: -1}},
+			Admission: AdmissionConfig{MaxWait: 200},
+		},
+	})
+	workerPool := sf.workerPool
+	go workerPool.Run()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := workerPool.Enlist(NewJob(ctx, "running", nil)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, func() bool { return workerPool.Pending() == 0 })
+
+	start := time.Now()
+	w := httptest.NewRecorder()
+	sf.handleGenerate(w, httptest.NewRequest("POST", "/generate", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Fatal("expected a Retry-After header")
+	}
+	if waited := time.Since(start); waited > 2*time.Second {
+		t.Fatalf("expected the job to be turned away after 200ms, waited %v", waited)
+	}
+	if stats := workerPool.QueueStats(); stats.Expired != 1 {
+		t.Fatalf("expected 1 expired job, got %+v", stats)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	workerPool := NewWorkerPool(WorkerPoolConfig{
+		Workers: []WorkerConfig{{Host: "http://worker", Capacity: 2}},
+	})
+	job := NewJob(context.Background(), "", nil)
+
+	if retry := workerPool.RetryAfter(job); retry != time.Second {
+		t.Fatalf("expected a one second minimum, got %v", retry)
+	}
+
+	now := time.Now()
+	workerPool.throughput.observe(now, 0)
+	workerPool.throughput.observe(now.Add(10*time.Second), 5)
+	if rate := workerPool.throughput.rate(); rate != 0.5 {
+		t.Fatalf("expected 0.5 jobs per second, got %v", rate)
+	}
+	if wait := workerPool.EstimateWait(job, 3); wait != 8*time.Second {
+		t.Fatalf("expected 8s for four jobs at 0.5/s, got %v", wait)
+	}
+
+	// Old samples fall out of the window.
+	workerPool.throughput.observe(now.Add(40*time.Second), 20)
+	workerPool.throughput.observe(now.Add(100*time.Second), 44)
+	if rate := workerPool.throughput.rate(); rate != 0.4 {
+		t.Fatalf("expected the rate over the last minute, got %v", rate)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/dgryski/go-rendezvous"
+)
+
+const affinityDefaultMaxLoad = 1.0
+
+// AffinityConfig selects how a job's session key is derived. The header is
+// tried first, then the claim, then a hash of the start of the prompt.
+type AffinityConfig struct {
+	Header      string   `json:"header,omitempty"`
+	ClaimPath   []string `json:"claimPath,omitempty"`
+	PrefixBytes int      `json:"prefixBytes,omitempty"`
+	MaxLoad     float64  `json:"maxLoad,omitempty"`
+}
+
+func (c *AffinityConfig) defaults() {
+	if c.MaxLoad <= 0 {
+		c.MaxLoad = affinityDefaultMaxLoad
+	}
+}
+
+// affinityBalancer rendezvous-hashes each session onto a live worker, so that
+// repeated prompts land on a warm KV cache. Sessions wait for their worker
+// while its load, counting the jobs already waiting for it, is below
+// MaxLoad, and spill over to the least loaded free worker otherwise.
+type affinityBalancer struct {
+	config   AffinityConfig
+	fallback Balancer
+}
+
+func newAffinityBalancer(config AffinityConfig) *affinityBalancer {
+	config.defaults()
+	return &affinityBalancer{
+		config:   config,
+		fallback: &leastLoadBalancer{},
+	}
+}
+
+func (b *affinityBalancer) Pick(candidates []*Worker, job *Job) *Worker {
+	return b.PickLive(candidates, job, nil)
+}
+
+func (b *affinityBalancer) PickLive(candidates []*Worker, job *Job, held map[*Worker]int) *Worker {
+	if key := b.sessionKey(job); key != "" {
+		nodes := make([]string, len(candidates))
+		workers := make(map[string]*Worker, len(candidates))
+		for i, worker := range candidates {
+			nodes[i] = worker.id
+			workers[worker.id] = worker
+		}
+
+		worker := workers[rendezvous.New(nodes, xxhash.Sum64String).Lookup(key)]
+		load := float64(worker.Outstanding()+held[worker]) / float64(worker.queue.Capacity())
+		if load < b.config.MaxLoad {
+			if worker.hasSlot() {
+				return worker
+			}
+			if held != nil {
+				held[worker]++
+			}
+			return nil
+		}
+	}
+
+	var free []*Worker
+	for _, worker := range candidates {
+		if worker.hasSlot() {
+			free = append(free, worker)
+		}
+	}
+	if len(free) == 0 {
+		return nil
+	}
+	return b.fallback.Pick(free, job)
+}
+
+func (b *affinityBalancer) sessionKey(job *Job) string {
+	if job == nil {
+		return ""
+	}
+
+	if b.config.Header != "" && job.Header != nil {
+		if key := job.Header.Get(b.config.Header); key != "" {
+			return key
+		}
+	}
+
+	if b.config.ClaimPath != nil {
+		if claim, ok := GetJsonPath(job.Claims, b.config.ClaimPath); ok && claim != nil {
+			return fmt.Sprint(claim)
+		}
+	}
+
+	if b.config.PrefixBytes > 0 && len(job.Payload) > 0 {
+		prefix := job.Payload
+		if len(prefix) > b.config.PrefixBytes {
+			prefix = prefix[:b.config.PrefixBytes]
+		}
+		return strconv.FormatUint(xxhash.Sum64(prefix), 16)
+	}
+
+	return ""
+}
@@ -1 +1,116 @@
 package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+)
+
+const (
+	allocatorDefaultCharsPerToken = 4.0
+	allocatorDefaultTokenHeader   = "X-Prompt-Tokens"
+)
+
+var errPromptTooLarge = errors.New("prompt does not fit any worker's context window")
+
+// maxTokensPaths are where the supported backends take the number of tokens
+// to generate, which has to fit in the context window too.
+var maxTokensPaths = [][]string{
+	{"max_tokens"},
+	{"n_predict"},
+	{"parameters", "max_new_tokens"},
+	{"options", "num_predict"},
+}
+
+// AllocatorConfig tunes how a prompt's size in tokens is estimated. Clients
+// that know the exact count can send it in TokenHeader instead.
+type AllocatorConfig struct {
+	CharsPerToken float64 `json:"charsPerToken,omitempty"`
+	TokenHeader   string  `json:"tokenHeader,omitempty"`
+}
+
+func (c *AllocatorConfig) defaults() {
+	if c.CharsPerToken <= 0 {
+		c.CharsPerToken = allocatorDefaultCharsPerToken
+	}
+	if c.TokenHeader == "" {
+		c.TokenHeader = allocatorDefaultTokenHeader
+	}
+}
+
+// Allocator keeps jobs off workers whose context window is too small for
+// them.
+type Allocator struct {
+	charsPerToken float64
+	tokenHeader   string
+}
+
+func NewAllocator(config AllocatorConfig) *Allocator {
+	config.defaults()
+	return &Allocator{
+		charsPerToken: config.CharsPerToken,
+		tokenHeader:   config.TokenHeader,
+	}
+}
+
+// Estimate returns the number of tokens the job needs, counting the prompt
+// and the completion it asks for.
+func (a *Allocator) Estimate(job *Job) int {
+	var body map[string]any
+	if err := json.Unmarshal(job.Payload, &body); err != nil {
+		body = nil
+	}
+
+	tokens := 0
+	if job.Header != nil {
+		tokens, _ = strconv.Atoi(job.Header.Get(a.tokenHeader))
+	}
+	if tokens <= 0 {
+		chars := len(job.Payload)
+		if n, ok := promptLength(body); ok {
+			chars = n
+		}
+		tokens = int(math.Ceil(float64(chars) / a.charsPerToken))
+	}
+
+	for _, path := range maxTokensPaths {
+		if n, ok := GetJsonPath(body, path); ok {
+			if n, ok := n.(float64); ok && n > 0 {
+				tokens += int(n)
+				break
+			}
+		}
+	}
+
+	return tokens
+}
+
+// Fits reports whether w's context window can hold the job.
+func (a *Allocator) Fits(w *Worker, job *Job) bool {
+	return w.contextWindow <= 0 || job.Tokens <= w.contextWindow
+}
+
+// promptLength returns the number of characters of prompt text in a JSON
+// payload, whether it is a completion or a chat request.
+func promptLength(body map[string]any) (int, bool) {
+	for _, key := range []string{"prompt", "inputs"} {
+		if prompt, ok := body[key].(string); ok {
+			return len(prompt), true
+		}
+	}
+
+	messages, ok := body["messages"].([]any)
+	if !ok {
+		return 0, false
+	}
+	chars := 0
+	for _, message := range messages {
+		if content, ok := GetJsonPath(message, []string{"content"}); ok {
+			if content, ok := content.(string); ok {
+				chars += len(content)
+			}
+		}
+	}
+	return chars, true
+}
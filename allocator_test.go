@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAllocatorEstimate(t *testing.T) {
+	allocator := NewAllocator(AllocatorConfig{})
+
+	tests := []struct {
+		name    string
+		payload string
+		header  http.Header
+		tokens  int
+	}{
+		{"raw", strings.Repeat("a", 40), nil, 10},
+		{"prompt", `{"prompt":"` + strings.Repeat("a", 40) + `","max_tokens":5}`, nil, 15},
+		{"chat", `{"messages":[{"role":"system","content":"` + strings.Repeat("a", 20) + `"},{"role":"user","content":"` + strings.Repeat("a", 20) + `"}]}`, nil, 10},
+		{"tgi", `{"inputs":"` + strings.Repeat("a", 8) + `","parameters":{"max_new_tokens":100}}`, nil, 102},
+		{"ollama", `{"prompt":"aaaa","options":{"num_predict":3}}`, nil, 4},
+		{"reported", `{"prompt":"aaaa","n_predict":10}`, http.Header{"X-Prompt-Tokens": {"500"}}, 510},
+	}
+
+	for _, test := range tests {
+		job := NewJob(context.Background(), test.name, []byte(test.payload))
+		job.Header = test.header
+		if tokens := allocator.Estimate(job); tokens != test.tokens {
+			t.Errorf("%s: expected %d tokens, got %d", test.name, test.tokens, tokens)
+		}
+	}
+}
+
+func TestAllocatorRouting(t *testing.T) {
+	sim := simulateWorker()
+	defer sim.Close()
+
+	sf := New(StarFleetConfig{
+		WorkerPoolConfig: WorkerPoolConfig{
+			Workers: []WorkerConfig{
+				{Id: "small", Host: sim.URL, Capacity: 1, ContextWindow: 100},
+				{Id: "large", Host: sim.URL, Capacity: 1, ContextWindow: 1000},
+			},
+		},
+	})
+	workerPool := sf.workerPool
+
+	long := NewJob(context.Background(), "long", []byte(strings.Repeat("a", 2000)))
+	long.Tokens = workerPool.allocator.Estimate(long)
+	for i := 0; i < 10; i++ {
+		if worker := workerPool.getWorker(long); worker == nil || worker.id != "large" {
+			t.Fatalf("expected long prompts on the large worker, got %v", worker)
+		}
+	}
+
+	huge := NewJob(context.Background(), "huge", []byte(strings.Repeat("a", 8000)))
+	if err := workerPool.Enlist(huge); !errors.Is(err, errPromptTooLarge) {
+		t.Fatalf("expected prompt to be too large, got %v", err)
+	}
+
+	r := httptest.NewRequest("POST", "/generate", strings.NewReader(strings.Repeat("a", 8000)))
+	w := httptest.NewRecorder()
+	sf.handleGenerate(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "2000 tokens") {
+		t.Fatalf("expected the message to give the prompt size, got %q", w.Body.String())
+	}
+	if stats := workerPool.Get("large").Stats(); stats.Fails != 0 || stats.Requests != 0 {
+		t.Fatal("expected oversized prompts never to reach a worker")
+	}
+}
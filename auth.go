@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"os"
@@ -8,10 +9,13 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// AuthConfig validates JWTs. Selector maps worker label keys to the claim
+// paths holding the values a request's workers must be labelled with.
 type AuthConfig struct {
-	JwtSecretKey    string   `json:"jwtSecretKey,omitempty"`
-	JwtSecretKeyEnv string   `json:"jwtSecretKeyEnv,omitempty"`
-	RolePath        []string `json:"rolePath,omitempty"`
+	JwtSecretKey    string              `json:"jwtSecretKey,omitempty"`
+	JwtSecretKeyEnv string              `json:"jwtSecretKeyEnv,omitempty"`
+	RolePath        []string            `json:"rolePath,omitempty"`
+	Selector        map[string][]string `json:"selector,omitempty"`
 }
 
 func (c *AuthConfig) defaults() {
@@ -23,6 +27,7 @@ func (c *AuthConfig) defaults() {
 type Auth struct {
 	jwtSecretKey []byte
 	rolePath     []string
+	selector     map[string][]string
 }
 
 func NewAuth(config AuthConfig) *Auth {
@@ -30,6 +35,7 @@ func NewAuth(config AuthConfig) *Auth {
 	return &Auth{
 		jwtSecretKey: []byte(config.JwtSecretKey),
 		rolePath:     config.RolePath,
+		selector:     config.Selector,
 	}
 }
 
@@ -44,10 +50,50 @@ func (a *Auth) Middleware(next http.Handler) http.HandlerFunc {
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		ctx := context.WithValue(r.Context(), claimsKey{}, claims)
+		if selector := a.getSelector(claims); selector != nil {
+			ctx = context.WithValue(ctx, selectorKey{}, selector)
+		}
+
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+type claimsKey struct{}
+
+type selectorKey struct{}
+
+// SelectorFromContext returns the worker label selector derived from the
+// request's claims, or nil if the request may run on any worker.
+func SelectorFromContext(ctx context.Context) map[string]string {
+	selector, _ := ctx.Value(selectorKey{}).(map[string]string)
+	return selector
+}
+
+// getSelector resolves the configured claim paths. Labels whose claim is
+// missing are left unconstrained.
+func (a *Auth) getSelector(claims map[string]any) map[string]string {
+	var selector map[string]string
+	for label, path := range a.selector {
+		value, ok := GetJsonPath(claims, path)
+		if !ok || value == nil {
+			continue
+		}
+		if selector == nil {
+			selector = make(map[string]string, len(a.selector))
+		}
+		selector[label] = fmt.Sprint(value)
+	}
+	return selector
+}
+
+// ClaimsFromContext returns the JWT claims validated by Auth.Middleware, or
+// nil if the request was not authenticated.
+func ClaimsFromContext(ctx context.Context) map[string]any {
+	claims, _ := ctx.Value(claimsKey{}).(map[string]any)
+	return claims
+}
+
 func (a *Auth) getClaims(token string) (map[string]any, error) {
 	t, err := jwt.Parse(token, func(t *jwt.Token) (any, error) {
 		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestAuthSelector(t *testing.T) {
+	auth := NewAuth(AuthConfig{
+		JwtSecretKey: "secret",
+		Selector: map[string][]string{
+			"tenant": {"org", "id"},
+			"tier":   {"tier"},
+		},
+	})
+
+	var selector map[string]string
+	var claims map[string]any
+	handler := auth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		selector = SelectorFromContext(r.Context())
+		claims = ClaimsFromContext(r.Context())
+	}))
+
+	tests := []struct {
+		claims   jwt.MapClaims
+		selector map[string]string
+	}{
+		{jwt.MapClaims{"org": map[string]any{"id": "acme"}, "tier": "gpu-a100"}, map[string]string{"tenant": "acme", "tier": "gpu-a100"}},
+		{jwt.MapClaims{"org": map[string]any{"id": "acme"}}, map[string]string{"tenant": "acme"}},
+		{jwt.MapClaims{"sub": "someone"}, nil},
+	}
+
+	for _, test := range tests {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, test.claims).SignedString([]byte("secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r := httptest.NewRequest("POST", "/generate", nil)
+		r.Header.Set("Authorization", token)
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+
+		if !reflect.DeepEqual(selector, test.selector) {
+			t.Errorf("claims %v: expected selector %v, got %v", test.claims, test.selector, selector)
+		}
+		if claims == nil {
+			t.Errorf("claims %v: expected claims in the request context", test.claims)
+		}
+	}
+}
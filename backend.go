@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	backendRaw      = "raw"
+	backendOpenAI   = "openai"
+	backendOllama   = "ollama"
+	backendTGI      = "tgi"
+	backendLlamaCpp = "llamacpp"
+)
+
+var backends = map[string]func(config WorkerConfig) Backend{
+	backendRaw:      func(WorkerConfig) Backend { return &rawBackend{} },
+	backendOpenAI:   func(WorkerConfig) Backend { return &openaiBackend{} },
+	backendOllama:   func(WorkerConfig) Backend { return &ollamaBackend{} },
+	backendTGI:      func(WorkerConfig) Backend { return &tgiBackend{} },
+	backendLlamaCpp: func(WorkerConfig) Backend { return &llamacppBackend{} },
+}
+
+// Backend adapts a model server's wire format to starfleet jobs. It builds
+// the upstream request and decodes the streamed response into tokens.
+type Backend interface {
+	Request(ctx context.Context, url string, payload []byte) (*http.Request, error)
+	Decode(body io.Reader) BackendStream
+}
+
+// BackendStream yields tokens until io.EOF. Usage may be called once the
+// stream has ended and returns nil if the server reported none.
+type BackendStream interface {
+	Next() (string, error)
+	Usage() *BackendUsage
+}
+
+// CapacityBackend is implemented by backends whose servers can report how
+// many requests they process in parallel.
+type CapacityBackend interface {
+	Capacity(ctx context.Context, get func(path string) (*http.Response, error)) (int, error)
+}
+
+type BackendUsage struct {
+	PromptTokens     int
+	CompletionTokens int
+	TotalDuration    time.Duration
+	LoadDuration     time.Duration
+	PromptDuration   time.Duration
+	EvalDuration     time.Duration
+}
+
+// BackendError is an error reported by the model server inside the stream,
+// as opposed to a transport or decoding failure.
+type BackendError struct {
+	Message string
+}
+
+func (e *BackendError) Error() string {
+	return e.Message
+}
+
+func NewBackend(config WorkerConfig) (Backend, error) {
+	newBackend, ok := backends[config.Backend]
+	if !ok {
+		return nil, fmt.Errorf("unknown worker backend %q", config.Backend)
+	}
+	return newBackend(config), nil
+}
+
+func newBackendRequest(ctx context.Context, url string, payload []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(payload))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Cache-Control", "no-cache")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Connection", "keep-alive")
+
+	return req, nil
+}
+
+type rawBackend struct{}
+
+func (b *rawBackend) Request(ctx context.Context, url string, payload []byte) (*http.Request, error) {
+	return newBackendRequest(ctx, url, payload)
+}
+
+func (b *rawBackend) Decode(body io.Reader) BackendStream {
+	return &rawStream{body: body}
+}
+
+type rawStream struct {
+	body io.Reader
+}
+
+func (s *rawStream) Next() (string, error) {
+	data := make([]byte, 1024)
+	size, err := s.body.Read(data)
+	if size > 0 {
+		return string(data[:size]), nil
+	}
+	return "", err
+}
+
+func (s *rawStream) Usage() *BackendUsage {
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+type llamacppBackend struct{}
+
+// Request turns on streaming in the payload. Without it /completion answers
+// with a single JSON object, which the stream decoder would read as nothing.
+func (b *llamacppBackend) Request(ctx context.Context, url string, payload []byte) (*http.Request, error) {
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &body); err == nil && body != nil {
+		body["stream"] = json.RawMessage("true")
+		if payload, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := newBackendRequest(ctx, url, payload)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	return req, nil
+}
+
+func (b *llamacppBackend) Decode(body io.Reader) BackendStream {
+	return &llamacppStream{decoder: NewSSEDecoder(body)}
+}
+
+// Capacity reads the number of parallel slots (the server's -np flag) from
+// /props, falling back to counting the entries of /slots on older servers.
+func (b *llamacppBackend) Capacity(ctx context.Context, get func(path string) (*http.Response, error)) (int, error) {
+	var props struct {
+		TotalSlots int `json:"total_slots"`
+	}
+	if err := getJson(get, "/props", &props); err == nil && props.TotalSlots > 0 {
+		return props.TotalSlots, nil
+	}
+
+	var slots []json.RawMessage
+	if err := getJson(get, "/slots", &slots); err != nil {
+		return 0, err
+	}
+	if len(slots) == 0 {
+		return 0, fmt.Errorf("llama.cpp server reported no slots")
+	}
+	return len(slots), nil
+}
+
+type llamacppResponse struct {
+	Content string `json:"content"`
+	Stop    bool   `json:"stop"`
+	Timings *struct {
+		PromptN     int     `json:"prompt_n"`
+		PromptMs    float64 `json:"prompt_ms"`
+		PredictedN  int     `json:"predicted_n"`
+		PredictedMs float64 `json:"predicted_ms"`
+	} `json:"timings"`
+	Error json.RawMessage `json:"error"`
+}
+
+// llamacppStream decodes the SSE stream served by the llama.cpp HTTP server
+// on /completion when "stream" is set in the payload.
+type llamacppStream struct {
+	decoder *SSEDecoder
+	usage   *BackendUsage
+	done    bool
+}
+
+func (s *llamacppStream) Next() (string, error) {
+	for !s.done {
+		event, err := s.decoder.Next()
+		if err != nil {
+			return "", err
+		}
+
+		var jsn llamacppResponse
+		if err := json.Unmarshal([]byte(event.Data), &jsn); err != nil {
+			return "", err
+		}
+
+		if len(jsn.Error) > 0 {
+			return "", &BackendError{Message: llamacppErrorMessage(jsn.Error)}
+		}
+
+		if jsn.Stop {
+			s.done = true
+			s.usage = &BackendUsage{}
+			if t := jsn.Timings; t != nil {
+				s.usage.PromptTokens = t.PromptN
+				s.usage.CompletionTokens = t.PredictedN
+				s.usage.PromptDuration = time.Duration(t.PromptMs * float64(time.Millisecond))
+				s.usage.EvalDuration = time.Duration(t.PredictedMs * float64(time.Millisecond))
+			}
+		}
+
+		if jsn.Content != "" {
+			return jsn.Content, nil
+		}
+	}
+
+	return "", io.EOF
+}
+
+func (s *llamacppStream) Usage() *BackendUsage {
+	return s.usage
+}
+
+func llamacppErrorMessage(raw json.RawMessage) string {
+	var obj struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(raw, &obj); err == nil && obj.Message != "" {
+		return obj.Message
+	}
+	var str string
+	if err := json.Unmarshal(raw, &str); err == nil {
+		return str
+	}
+	return string(raw)
+}
+
+func getJson(get func(path string) (*http.Response, error), path string, v any) error {
+	res, err := get(path)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %s", path, res.Status)
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
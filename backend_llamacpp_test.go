@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func simulateLlamaCppWorker(props string, slots int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.WriteHeader(http.StatusOK)
+		case "/props":
+			if props == "" {
+				http.NotFound(w, r)
+				return
+			}
+			fmt.Fprint(w, props)
+		case "/slots":
+			fmt.Fprintf(w, "[%s]", strings.TrimSuffix(strings.Repeat(`{"id":0},`, slots), ","))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestLlamaCppBackend(t *testing.T) {
+	stream := "data: {\"content\":\"Hel\",\"stop\":false}\n\n" +
+		"data: {\"content\":\"lo\",\"stop\":false}\n\n" +
+		"data: {\"content\":\"\",\"stop\":true,\"timings\":{\"prompt_n\":4,\"prompt_ms\":12.5,\"predicted_n\":2,\"predicted_ms\":40}}\n\n"
+
+	s := (&llamacppBackend{}).Decode(strings.NewReader(stream))
+	out, err := collectTokens(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "Hello" {
+		t.Fatalf("expected Hello, got %q", out)
+	}
+	if usage := s.Usage(); usage == nil || usage.PromptTokens != 4 || usage.CompletionTokens != 2 || usage.EvalDuration != 40*time.Millisecond {
+		t.Fatalf("unexpected usage %+v", usage)
+	}
+
+	req, err := (&llamacppBackend{}).Request(context.Background(), "http://worker/completion", []byte(`{"prompt":"Hi","stream":false}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body map[string]any
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body["stream"] != true || body["prompt"] != "Hi" {
+		t.Fatalf("expected streaming to be turned on, got %v", body)
+	}
+
+	_, err = collectTokens((&llamacppBackend{}).Decode(strings.NewReader("data: {\"error\":{\"code\":500,\"message\":\"slot unavailable\"}}\n\n")))
+	if berr, ok := err.(*BackendError); !ok || berr.Message != "slot unavailable" {
+		t.Fatalf("expected backend error, got %v", err)
+	}
+}
+
+func TestLlamaCppCapacityDiscovery(t *testing.T) {
+	for _, test := range []struct {
+		sim      *httptest.Server
+		capacity int
+	}{
+		{simulateLlamaCppWorker(`{"total_slots":4}`, 0), 4},
+		{simulateLlamaCppWorker("", 3), 3},
+	} {
+		sim := test.sim
+		defer sim.Close()
+
+		worker := NewWorker(WorkerConfig{Host: sim.URL, Backend: backendLlamaCpp, CheckAlive: true})
+		if worker.Stats().Capacity != 1 {
+			t.Fatalf("expected placeholder capacity of 1, got %d", worker.Stats().Capacity)
+		}
+
+		worker.discoverCapacity()
+		if capacity := worker.Stats().Capacity; capacity != test.capacity {
+			t.Fatalf("expected discovered capacity of %d, got %d", test.capacity, capacity)
+		}
+	}
+
+	// Workers without liveness checks still discover their capacity once.
+	sim := simulateLlamaCppWorker(`{"total_slots":4}`, 0)
+	defer sim.Close()
+	worker := NewWorker(WorkerConfig{Host: sim.URL, Backend: backendLlamaCpp})
+	defer worker.Stop()
+	go worker.Work()
+	waitFor(t, 5*time.Second, func() bool { return worker.Stats().Capacity == 4 })
+}
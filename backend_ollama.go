@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+)
+
+type ollamaBackend struct{}
+
+func (b *ollamaBackend) Request(ctx context.Context, url string, payload []byte) (*http.Request, error) {
+	req, err := newBackendRequest(ctx, url, payload)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/x-ndjson")
+	return req, nil
+}
+
+func (b *ollamaBackend) Decode(body io.Reader) BackendStream {
+	return &ollamaStream{decoder: json.NewDecoder(body)}
+}
+
+type ollamaResponse struct {
+	Response string `json:"response"`
+	Message  *struct {
+		Content string `json:"content"`
+	} `json:"message"`
+	Done               bool   `json:"done"`
+	Error              string `json:"error"`
+	TotalDuration      int64  `json:"total_duration"`
+	LoadDuration       int64  `json:"load_duration"`
+	PromptEvalCount    int    `json:"prompt_eval_count"`
+	PromptEvalDuration int64  `json:"prompt_eval_duration"`
+	EvalCount          int    `json:"eval_count"`
+	EvalDuration       int64  `json:"eval_duration"`
+}
+
+// ollamaStream decodes Ollama's newline-delimited JSON stream, as served by
+// both /api/generate and /api/chat.
+type ollamaStream struct {
+	decoder *json.Decoder
+	usage   *BackendUsage
+	done    bool
+}
+
+func (s *ollamaStream) Next() (string, error) {
+	for !s.done {
+		var jsn ollamaResponse
+		if err := s.decoder.Decode(&jsn); err != nil {
+			return "", err
+		}
+
+		if jsn.Error != "" {
+			return "", &BackendError{Message: jsn.Error}
+		}
+
+		if jsn.Done {
+			s.done = true
+			s.usage = &BackendUsage{
+				PromptTokens:     jsn.PromptEvalCount,
+				CompletionTokens: jsn.EvalCount,
+				TotalDuration:    time.Duration(jsn.TotalDuration),
+				LoadDuration:     time.Duration(jsn.LoadDuration),
+				PromptDuration:   time.Duration(jsn.PromptEvalDuration),
+				EvalDuration:     time.Duration(jsn.EvalDuration),
+			}
+		}
+
+		token := jsn.Response
+		if jsn.Message != nil {
+			token = jsn.Message.Content
+		}
+
+		if token != "" {
+			return token, nil
+		}
+	}
+
+	return "", io.EOF
+}
+
+func (s *ollamaStream) Usage() *BackendUsage {
+	return s.usage
+}
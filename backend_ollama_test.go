@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func simulateOllamaWorker() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.WriteHeader(http.StatusOK)
+			return
+		case "/api/generate":
+			w.Header().Set("Content-Type", "application/x-ndjson")
+
+			flusher, ok := w.(http.Flusher)
+			if !ok {
+				fmt.Println("Streaming not supported")
+				return
+			}
+
+			for i := 'a'; i <= 'e'; i++ {
+				fmt.Fprintf(w, `{"model":"llama2","response":"%c","done":false}`+"\n", i)
+				flusher.Flush()
+			}
+
+			fmt.Fprint(w, `{"model":"llama2","response":"","done":true,"total_duration":5000000000,`)
+			flusher.Flush()
+			fmt.Fprint(w, `"load_duration":1000000,"prompt_eval_count":12,"prompt_eval_duration":300000000,"eval_count":5,"eval_duration":2000000000}`+"\n")
+			flusher.Flush()
+			return
+		default:
+			http.NotFound(w, r)
+			return
+		}
+	}))
+}
+
+func TestOllamaBackend(t *testing.T) {
+	simWorker := simulateOllamaWorker()
+	defer simWorker.Close()
+
+	worker := NewWorker(WorkerConfig{Host: simWorker.URL, Capacity: 1, Backend: backendOllama})
+	go worker.Work()
+
+	job := NewJob(context.Background(), "1", []byte(`{"model":"llama2","prompt":"hi"}`))
+	worker.Submit(job)
+
+	var out strings.Builder
+	for {
+		select {
+		case token := <-job.Output:
+			out.WriteString(token)
+			continue
+		case err := <-job.Err:
+			t.Fatal(err)
+		case <-job.Ctx.Done():
+		}
+		break
+	}
+
+	for len(job.Output) > 0 {
+		out.WriteString(<-job.Output)
+	}
+
+	if out.String() != "abcde" {
+		t.Fatalf("expected abcde, got %q", out.String())
+	}
+
+	stats := worker.Stats()
+	if stats.PromptTokens != 12 || stats.CompletionTokens != 5 {
+		t.Fatalf("unexpected token counts %+v", stats)
+	}
+	if stats.PromptEvalTime != 300 || stats.EvalTime != 2000 || stats.LoadTime != 1 {
+		t.Fatalf("unexpected durations %+v", stats)
+	}
+}
+
+func TestOllamaBackendError(t *testing.T) {
+	stream := `{"response":"a","done":false}` + "\n" + `{"error":"model 'x' not found"}` + "\n"
+
+	out, err := collectTokens((&ollamaBackend{}).Decode(strings.NewReader(stream)))
+	if out != "a" {
+		t.Fatalf("expected a, got %q", out)
+	}
+	if berr, ok := err.(*BackendError); !ok || berr.Message != "model 'x' not found" {
+		t.Fatalf("expected backend error, got %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+type openaiBackend struct{}
+
+func (b *openaiBackend) Request(ctx context.Context, url string, payload []byte) (*http.Request, error) {
+	req, err := newBackendRequest(ctx, url, payload)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	return req, nil
+}
+
+func (b *openaiBackend) Decode(body io.Reader) BackendStream {
+	return &openaiStream{decoder: NewSSEDecoder(body)}
+}
+
+type openaiResponse struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type openaiStream struct {
+	decoder *SSEDecoder
+	usage   *BackendUsage
+}
+
+func (s *openaiStream) Next() (string, error) {
+	for {
+		event, err := s.decoder.Next()
+		if err != nil {
+			return "", err
+		}
+
+		token, err := s.filter(event.Data)
+		if err != nil {
+			return "", err
+		}
+
+		if token != "" {
+			return token, nil
+		}
+	}
+}
+
+func (s *openaiStream) Usage() *BackendUsage {
+	return s.usage
+}
+
+func (s *openaiStream) filter(data string) (string, error) {
+	if data == "[DONE]" {
+		return "", io.EOF
+	}
+
+	var jsn openaiResponse
+	if err := json.Unmarshal([]byte(data), &jsn); err != nil {
+		return "", err
+	}
+
+	if jsn.Error != nil {
+		return "", &BackendError{Message: jsn.Error.Message}
+	}
+
+	if jsn.Usage != nil {
+		s.usage = &BackendUsage{
+			PromptTokens:     jsn.Usage.PromptTokens,
+			CompletionTokens: jsn.Usage.CompletionTokens,
+		}
+	}
+
+	if len(jsn.Choices) > 0 {
+		return jsn.Choices[0].Delta.Content, nil
+	}
+
+	return "", nil
+}
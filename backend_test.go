@@ -0,0 +1,112 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func collectTokens(stream BackendStream) (string, error) {
+	var out strings.Builder
+	for {
+		token, err := stream.Next()
+		if err == io.EOF {
+			return out.String(), nil
+		}
+		if err != nil {
+			return out.String(), err
+		}
+		out.WriteString(token)
+	}
+}
+
+func TestOpenAIBackend(t *testing.T) {
+	stream := "data: {\"choices\":[{\"delta\":{\"content\":\"Hel\"}}]}\n\n" +
+		"data: {\"choices\":[{\"delta\":{\"content\":\"lo\"}}]}\n\n" +
+		"data: {\"choices\":[],\"usage\":{\"prompt_tokens\":3,\"completion_tokens\":2}}\n\n" +
+		"data: [DONE]\n\n"
+
+	s := (&openaiBackend{}).Decode(iotest.OneByteReader(strings.NewReader(stream)))
+	out, err := collectTokens(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "Hello" {
+		t.Fatalf("expected Hello, got %q", out)
+	}
+	if usage := s.Usage(); usage == nil || usage.PromptTokens != 3 || usage.CompletionTokens != 2 {
+		t.Fatalf("unexpected usage %+v", usage)
+	}
+}
+
+func TestOpenAIBackendError(t *testing.T) {
+	stream := "data: {\"error\":{\"message\":\"model overloaded\"}}\n\n"
+
+	_, err := collectTokens((&openaiBackend{}).Decode(strings.NewReader(stream)))
+	if berr, ok := err.(*BackendError); !ok || berr.Message != "model overloaded" {
+		t.Fatalf("expected backend error, got %v", err)
+	}
+}
+
+func TestRawBackend(t *testing.T) {
+	out, err := collectTokens((&rawBackend{}).Decode(iotest.HalfReader(strings.NewReader("abcdef"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "abcdef" {
+		t.Fatalf("expected abcdef, got %q", out)
+	}
+}
+
+func TestNewBackend(t *testing.T) {
+	config := WorkerConfig{OpenAI: true}
+	config.defaults()
+	if b, err := NewBackend(config); err != nil {
+		t.Fatal(err)
+	} else if _, ok := b.(*openaiBackend); !ok {
+		t.Fatalf("expected openai backend, got %T", b)
+	}
+
+	if _, err := NewBackend(WorkerConfig{Backend: "nope"}); err == nil {
+		t.Fatal("expected error for unknown backend")
+	}
+}
+
+func TestTGIBackend(t *testing.T) {
+	stream := "data:{\"token\":{\"id\":1,\"text\":\"<s>\",\"special\":true},\"generated_text\":null,\"details\":null}\n\n" +
+		"data:{\"token\":{\"id\":2,\"text\":\"Hel\",\"special\":false},\"generated_text\":null,\"details\":null}\n\n" +
+		"data:{\"token\":{\"id\":3,\"text\":\"lo\",\"special\":false},\"generated_text\":\"Hello\",\"details\":{\"finish_reason\":\"length\",\"generated_tokens\":2}}\n\n" +
+		"data:{\"token\":{\"id\":4,\"text\":\"ignored\",\"special\":false},\"generated_text\":null,\"details\":null}\n\n"
+
+	s := (&tgiBackend{}).Decode(iotest.OneByteReader(strings.NewReader(stream)))
+	out, err := collectTokens(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "Hello" {
+		t.Fatalf("expected Hello, got %q", out)
+	}
+	if usage := s.Usage(); usage == nil || usage.CompletionTokens != 2 {
+		t.Fatalf("unexpected usage %+v", usage)
+	}
+}
+
+func TestTGIBackendError(t *testing.T) {
+	stream := "data:{\"token\":{\"id\":2,\"text\":\"Hel\",\"special\":false},\"generated_text\":null,\"details\":null}\n\n" +
+		"data:{\"error\":\"Request failed during generation: out of memory\",\"error_type\":\"generation\"}\n\n"
+
+	out, err := collectTokens((&tgiBackend{}).Decode(strings.NewReader(stream)))
+	if out != "Hel" {
+		t.Fatalf("expected Hel, got %q", out)
+	}
+	if _, ok := err.(*BackendError); !ok {
+		t.Fatalf("expected backend error, got %v", err)
+	}
+
+	stream = "event: error\ndata: Model is overloaded\n\n"
+	_, err = collectTokens((&tgiBackend{}).Decode(strings.NewReader(stream)))
+	if berr, ok := err.(*BackendError); !ok || berr.Message != "Model is overloaded" {
+		t.Fatalf("expected backend error with the server's message, got %v", err)
+	}
+}
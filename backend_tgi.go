@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+type tgiBackend struct{}
+
+func (b *tgiBackend) Request(ctx context.Context, url string, payload []byte) (*http.Request, error) {
+	req, err := newBackendRequest(ctx, url, payload)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	return req, nil
+}
+
+func (b *tgiBackend) Decode(body io.Reader) BackendStream {
+	return &tgiStream{decoder: NewSSEDecoder(body)}
+}
+
+type tgiResponse struct {
+	Token *struct {
+		Text    string `json:"text"`
+		Special bool   `json:"special"`
+	} `json:"token"`
+	GeneratedText *string `json:"generated_text"`
+	Details       *struct {
+		FinishReason    string `json:"finish_reason"`
+		GeneratedTokens int    `json:"generated_tokens"`
+	} `json:"details"`
+	Error     string `json:"error"`
+	ErrorType string `json:"error_type"`
+}
+
+// tgiStream decodes the SSE stream served by HuggingFace Text Generation
+// Inference on /generate_stream.
+type tgiStream struct {
+	decoder *SSEDecoder
+	usage   *BackendUsage
+	done    bool
+}
+
+func (s *tgiStream) Next() (string, error) {
+	for !s.done {
+		event, err := s.decoder.Next()
+		if err != nil {
+			return "", err
+		}
+
+		// Error events are not always JSON, in which case the data is the
+		// server's message.
+		var jsn tgiResponse
+		if err := json.Unmarshal([]byte(event.Data), &jsn); err != nil {
+			if event.Event == "error" {
+				return "", &BackendError{Message: event.Data}
+			}
+			return "", err
+		}
+
+		if jsn.Error != "" || event.Event == "error" {
+			msg := jsn.Error
+			if msg == "" {
+				msg = event.Data
+			}
+			if jsn.ErrorType != "" {
+				msg = jsn.ErrorType + ": " + msg
+			}
+			return "", &BackendError{Message: msg}
+		}
+
+		if jsn.GeneratedText != nil {
+			s.done = true
+			s.usage = &BackendUsage{}
+			if jsn.Details != nil {
+				s.usage.CompletionTokens = jsn.Details.GeneratedTokens
+			}
+		}
+
+		if jsn.Token != nil && !jsn.Token.Special && jsn.Token.Text != "" {
+			return jsn.Token.Text, nil
+		}
+	}
+
+	return "", io.EOF
+}
+
+func (s *tgiStream) Usage() *BackendUsage {
+	return s.usage
+}
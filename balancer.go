@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	balancerLeastLoad          = "least-load"
+	balancerRoundRobin         = "round-robin"
+	balancerWeightedRoundRobin = "weighted-round-robin"
+	balancerLeastOutstanding   = "least-outstanding"
+	balancerPowerOfTwo         = "power-of-two"
+	balancerEWMA               = "ewma"
+	balancerAffinity           = "affinity"
+)
+
+var balancers = map[string]func(config WorkerPoolConfig) Balancer{
+	balancerLeastLoad:          func(WorkerPoolConfig) Balancer { return &leastLoadBalancer{} },
+	balancerRoundRobin:         func(WorkerPoolConfig) Balancer { return &roundRobinBalancer{} },
+	balancerWeightedRoundRobin: func(WorkerPoolConfig) Balancer { return &weightedRoundRobinBalancer{current: make(map[*Worker]int)} },
+	balancerLeastOutstanding:   func(WorkerPoolConfig) Balancer { return &leastOutstandingBalancer{} },
+	balancerPowerOfTwo:         func(WorkerPoolConfig) Balancer { return &powerOfTwoBalancer{} },
+	balancerEWMA:               func(WorkerPoolConfig) Balancer { return &ewmaBalancer{} },
+	balancerAffinity:           func(config WorkerPoolConfig) Balancer { return newAffinityBalancer(config.Affinity) },
+}
+
+// Balancer picks a worker for a job out of the available candidates. The
+// candidates are never empty and always listed in pool order.
+type Balancer interface {
+	Pick(candidates []*Worker, job *Job) *Worker
+}
+
+// A liveBalancer is shown every live candidate, busy or not, so that it can
+// hold a job for a particular worker. It returns a worker with a free slot,
+// or nil if the job should wait, counting it in held if held is not nil.
+type liveBalancer interface {
+	PickLive(candidates []*Worker, job *Job, held map[*Worker]int) *Worker
+}
+
+func NewBalancer(config WorkerPoolConfig) (Balancer, error) {
+	strategy := config.Strategy
+	if strategy == "" {
+		strategy = balancerLeastLoad
+	}
+	newBalancer, ok := balancers[strategy]
+	if !ok {
+		return nil, fmt.Errorf("unknown load balancing strategy %q", strategy)
+	}
+	return newBalancer(config), nil
+}
+
+// leastMetric returns the candidate with the lowest metric, breaking ties at
+// random.
+func leastMetric(candidates []*Worker, metric func(w *Worker) float64) *Worker {
+	var best *Worker
+	var least float64
+	for _, i := range rand.Perm(len(candidates)) {
+		worker := candidates[i]
+		if m := metric(worker); best == nil || m < least {
+			best = worker
+			least = m
+		}
+	}
+	return best
+}
+
+type leastLoadBalancer struct{}
+
+func (b *leastLoadBalancer) Pick(candidates []*Worker, job *Job) *Worker {
+	return leastMetric(candidates, (*Worker).Load)
+}
+
+type roundRobinBalancer struct {
+	next uint64
+}
+
+func (b *roundRobinBalancer) Pick(candidates []*Worker, job *Job) *Worker {
+	n := atomic.AddUint64(&b.next, 1) - 1
+	return candidates[n%uint64(len(candidates))]
+}
+
+// weightedRoundRobinBalancer is the smooth weighted round-robin used by
+// nginx, which interleaves heavier workers rather than sending them bursts.
+type weightedRoundRobinBalancer struct {
+	mu      sync.Mutex
+	current map[*Worker]int
+}
+
+func (b *weightedRoundRobinBalancer) Pick(candidates []*Worker, job *Job) *Worker {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var best *Worker
+	total := 0
+	for _, worker := range candidates {
+		b.current[worker] += worker.weight
+		total += worker.weight
+		if best == nil || b.current[worker] > b.current[best] {
+			best = worker
+		}
+	}
+	b.current[best] -= total
+	return best
+}
+
+type leastOutstandingBalancer struct{}
+
+func (b *leastOutstandingBalancer) Pick(candidates []*Worker, job *Job) *Worker {
+	return leastMetric(candidates, func(w *Worker) float64 {
+		return float64(w.Outstanding())
+	})
+}
+
+type powerOfTwoBalancer struct{}
+
+func (b *powerOfTwoBalancer) Pick(candidates []*Worker, job *Job) *Worker {
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+	i := rand.Intn(len(candidates))
+	j := rand.Intn(len(candidates) - 1)
+	if j >= i {
+		j++
+	}
+	if candidates[j].Load() < candidates[i].Load() {
+		return candidates[j]
+	}
+	return candidates[i]
+}
+
+// ewmaBalancer prefers workers with the lowest expected time to first token,
+// scaled by the work already waiting on them. Workers without samples score
+// zero so they are tried first.
+type ewmaBalancer struct{}
+
+func (b *ewmaBalancer) Pick(candidates []*Worker, job *Job) *Worker {
+	return leastMetric(candidates, func(w *Worker) float64 {
+		return w.FirstTokenEWMA() * float64(w.Outstanding()+1)
+	})
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// simulateBlockingWorker holds every generate request open until release is
+// closed, so that jobs stay outstanding while the pool distributes them.
+func simulateBlockingWorker(release chan struct{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+}
+
+func distribute(t *testing.T, strategy string, workers []WorkerConfig, jobs int, setup func(wp *WorkerPool)) []int {
+	release := make(chan struct{})
+	defer close(release)
+
+	for i := range workers {
+		sim := simulateBlockingWorker(release)
+		defer sim.Close()
+		workers[i].Host = sim.URL
+		workers[i].FirstTokenTimeout = -1
+	}
+
+	workerPool := NewWorkerPool(WorkerPoolConfig{Workers: workers, Strategy: strategy})
+	if setup != nil {
+		setup(workerPool)
+	}
+	go workerPool.Run()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for i := 0; i < jobs; i++ {
+		if err := workerPool.Enlist(NewJob(ctx, fmt.Sprint(i), nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	counts := make([]int, len(workers))
+	waitFor(t, 5*time.Second, func() bool {
+		total := 0
+		for i, stats := range workerPool.Stats() {
+			counts[i] = stats.Requests
+			total += stats.Requests
+		}
+		return total == jobs
+	})
+
+	t.Logf("%s: %v", strategy, counts)
+	return counts
+}
+
+func TestBalancerLeastLoad(t *testing.T) {
+	counts := distribute(t, balancerLeastLoad, []WorkerConfig{{Capacity: 9}, {Capacity: 3}}, 8, nil)
+	if counts[0] != 6 || counts[1] != 2 {
+		t.Fatalf("expected jobs spread by load, got %v", counts)
+	}
+}
+
+func TestBalancerRoundRobin(t *testing.T) {
+	counts := distribute(t, balancerRoundRobin, []WorkerConfig{{Capacity: 10}, {Capacity: 4}, {Capacity: 4}}, 9, nil)
+	for _, count := range counts {
+		if count != 3 {
+			t.Fatalf("expected an even rotation, got %v", counts)
+		}
+	}
+}
+
+func TestBalancerWeightedRoundRobin(t *testing.T) {
+	counts := distribute(t, balancerWeightedRoundRobin, []WorkerConfig{{Capacity: 10, Weight: 3}, {Capacity: 10}}, 8, nil)
+	if counts[0] != 6 || counts[1] != 2 {
+		t.Fatalf("expected a 3:1 split, got %v", counts)
+	}
+}
+
+func TestBalancerLeastOutstanding(t *testing.T) {
+	counts := distribute(t, balancerLeastOutstanding, []WorkerConfig{{Capacity: 10}, {Capacity: 5}}, 8, nil)
+	if counts[0] != 4 || counts[1] != 4 {
+		t.Fatalf("expected jobs spread regardless of capacity, got %v", counts)
+	}
+}
+
+func TestBalancerPowerOfTwo(t *testing.T) {
+	counts := distribute(t, balancerPowerOfTwo, []WorkerConfig{{Capacity: 9}, {Capacity: 3}}, 8, nil)
+	if counts[0] != 6 || counts[1] != 2 {
+		t.Fatalf("expected two choices out of two workers to balance by load, got %v", counts)
+	}
+
+	counts = distribute(t, balancerPowerOfTwo, []WorkerConfig{{Capacity: 20}, {Capacity: 20}, {Capacity: 20}, {Capacity: 20}}, 40, nil)
+	for _, count := range counts {
+		if count < 6 || count > 14 {
+			t.Fatalf("expected a roughly even spread, got %v", counts)
+		}
+	}
+}
+
+func TestBalancerEWMA(t *testing.T) {
+	counts := distribute(t, balancerEWMA, []WorkerConfig{{Capacity: 12}, {Capacity: 12}}, 12, func(wp *WorkerPool) {
+		wp.workers[0].observeFirstToken(100 * time.Millisecond)
+		wp.workers[1].observeFirstToken(500 * time.Millisecond)
+	})
+	if counts[0] != 10 || counts[1] != 2 {
+		t.Fatalf("expected the faster worker to take most jobs, got %v", counts)
+	}
+}
+
+func TestNewBalancer(t *testing.T) {
+	if _, err := NewBalancer(WorkerPoolConfig{Strategy: "random"}); err == nil {
+		t.Fatal("expected an error for an unknown strategy")
+	}
+	if b, err := NewBalancer(WorkerPoolConfig{}); err != nil {
+		t.Fatal(err)
+	} else if _, ok := b.(*leastLoadBalancer); !ok {
+		t.Fatalf("expected least-load by default, got %T", b)
+	}
+}
+
+func TestBalancerAffinity(t *testing.T) {
+	workerPool := NewWorkerPool(WorkerPoolConfig{
+		Workers: []WorkerConfig{
+			{Host: "http://worker-a", Capacity: 2},
+			{Host: "http://worker-b", Capacity: 2},
+			{Host: "http://worker-c", Capacity: 2},
+		},
+		Strategy: balancerAffinity,
+		Affinity: AffinityConfig{Header: "X-Session-ID", ClaimPath: []string{"sub"}, PrefixBytes: 16},
+	})
+
+	newJob := func(session string, sub string, payload string) *Job {
+		job := NewJob(context.Background(), "", []byte(payload))
+		job.Header = http.Header{}
+		if session != "" {
+			job.Header.Set("X-Session-ID", session)
+		}
+		if sub != "" {
+			job.Claims = map[string]any{"sub": sub}
+		}
+		return job
+	}
+
+	hits := make(map[*Worker]int)
+	for i := 0; i < 30; i++ {
+		session := fmt.Sprintf("session-%d", i)
+		first := workerPool.getWorker(newJob(session, "", ""))
+		for j := 0; j < 5; j++ {
+			if workerPool.getWorker(newJob(session, "", "")) != first {
+				t.Fatalf("session %s moved between workers", session)
+			}
+		}
+		hits[first]++
+	}
+	if len(hits) != 3 {
+		t.Fatalf("expected sessions to spread over every worker, got %v", hits)
+	}
+
+	if workerPool.getWorker(newJob("", "alice", "")) != workerPool.getWorker(newJob("", "alice", "different prompt")) {
+		t.Fatal("expected the JWT subject to pin the session")
+	}
+
+	system := "You are a helpful assistant. "
+	if workerPool.getWorker(newJob("", "", system+"Hello")) != workerPool.getWorker(newJob("", "", system+"Goodbye")) {
+		t.Fatal("expected a shared prompt prefix to pin the session")
+	}
+
+	// Fill the pinned worker to capacity and expect a spill over.
+	pinned := workerPool.getWorker(newJob("busy", "", ""))
+	pinned.outstanding = 2
+	if spilled := workerPool.getWorker(newJob("busy", "", "")); spilled == pinned {
+		t.Fatal("expected an overloaded worker to spill the session")
+	}
+	pinned.outstanding = 0
+	if workerPool.getWorker(newJob("busy", "", "")) != pinned {
+		t.Fatal("expected the session to return once the worker has capacity")
+	}
+}
+
+func TestBalancerAffinitySpill(t *testing.T) {
+	newPool := func(maxLoad float64) *WorkerPool {
+		return NewWorkerPool(WorkerPoolConfig{
+			Workers: []WorkerConfig{
+				{Id: "a", Host: "http://worker-a", Capacity: 2},
+				{Id: "b", Host: "http://worker-b", Capacity: 2},
+				{Id: "c", Host: "http://worker-c", Capacity: 2},
+			},
+			Strategy: balancerAffinity,
+			Affinity: AffinityConfig{Header: "X-Session-ID", MaxLoad: maxLoad},
+		})
+	}
+	newJob := func(session string) *Job {
+		job := NewJob(context.Background(), "", nil)
+		job.Header = http.Header{}
+		job.Header.Set("X-Session-ID", session)
+		return job
+	}
+
+	// A full worker spills its sessions to the least loaded worker, not to
+	// whichever worker the session would hash to next.
+	workerPool := newPool(0)
+	for i := 0; i < 20; i++ {
+		session := fmt.Sprintf("session-%d", i)
+		pinned := workerPool.getWorker(newJob(session))
+		for _, worker := range workerPool.Workers() {
+			worker.outstanding = 1
+		}
+		pinned.outstanding = 2
+		idle := workerPool.Workers()[0]
+		if idle == pinned {
+			idle = workerPool.Workers()[1]
+		}
+		idle.outstanding = 0
+
+		if spilled := workerPool.getWorker(newJob(session)); spilled != idle {
+			t.Fatalf("expected %s to spill to the idle worker %s, got %s", session, idle.id, spilled.id)
+		}
+		for _, worker := range workerPool.Workers() {
+			worker.outstanding = 0
+		}
+	}
+
+	// Above 1, MaxLoad lets sessions wait for their busy worker, counting the
+	// jobs already waiting for it.
+	workerPool = newPool(1.5)
+	pinned := workerPool.getWorker(newJob("busy"))
+	pinned.outstanding = 2
+	held := make(map[*Worker]int)
+	if worker := workerPool.pickWorker(workerPool.Workers(), newJob("busy"), 0, held); worker != nil || held[pinned] != 1 {
+		t.Fatalf("expected the session to wait for its worker, got %v", worker)
+	}
+	if worker := workerPool.pickWorker(workerPool.Workers(), newJob("busy"), 1, held); worker == nil || worker == pinned {
+		t.Fatalf("expected the session to spill once its worker is loaded, got %v", worker)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	breakerDefaultWindow         = 60
+	breakerDefaultErrorRate      = 0.5
+	breakerDefaultMinRequests    = 10
+	breakerDefaultCooldown       = 30
+	breakerDefaultHalfOpenTrials = 3
+)
+
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+type BreakerConfig struct {
+	Window         int     `json:"window,omitempty"`
+	ErrorRate      float64 `json:"errorRate,omitempty"`
+	MinRequests    int     `json:"minRequests,omitempty"`
+	Cooldown       int     `json:"cooldown,omitempty"`
+	HalfOpenTrials int     `json:"halfOpenTrials,omitempty"`
+}
+
+func (c *BreakerConfig) defaults() {
+	if c.Window <= 0 {
+		c.Window = breakerDefaultWindow
+	}
+	if c.ErrorRate <= 0 {
+		c.ErrorRate = breakerDefaultErrorRate
+	}
+	if c.MinRequests <= 0 {
+		c.MinRequests = breakerDefaultMinRequests
+	}
+	if c.Cooldown <= 0 {
+		c.Cooldown = breakerDefaultCooldown
+	}
+	if c.HalfOpenTrials <= 0 {
+		c.HalfOpenTrials = breakerDefaultHalfOpenTrials
+	}
+}
+
+type breakerBucket struct {
+	second    int64
+	successes int
+	failures  int
+}
+
+// Breaker is a circuit breaker over a sliding window of request outcomes.
+// It opens once the error rate in the window crosses the threshold, waits
+// out a cool-down, then lets a limited number of trial requests through
+// while half-open before closing again.
+type Breaker struct {
+	mu      sync.Mutex
+	state   BreakerState
+	buckets []breakerBucket
+
+	errorRate   float64
+	minRequests int
+	cooldown    time.Duration
+	trials      int
+
+	openedAt   time.Time
+	inflight   int
+	succeeded  int
+	generation uint64
+
+	onChange func(from, to BreakerState)
+	now      func() time.Time
+}
+
+func NewBreaker(config BreakerConfig) *Breaker {
+	config.defaults()
+	return &Breaker{
+		state:       BreakerClosed,
+		buckets:     make([]breakerBucket, config.Window),
+		errorRate:   config.ErrorRate,
+		minRequests: config.MinRequests,
+		cooldown:    time.Duration(config.Cooldown) * time.Second,
+		trials:      config.HalfOpenTrials,
+		now:         time.Now,
+	}
+}
+
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refresh()
+	return b.state
+}
+
+// Ready reports whether a request would currently be allowed, without
+// claiming a half-open trial.
+func (b *Breaker) Ready() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refresh()
+	switch b.state {
+	case BreakerClosed:
+		return true
+	case BreakerHalfOpen:
+		return b.inflight+b.succeeded < b.trials
+	default:
+		return false
+	}
+}
+
+// Allow claims permission for one request. Every allowed request must be
+// followed by Record or Cancel with the returned generation, which tells
+// apart requests admitted before the breaker last changed state.
+func (b *Breaker) Allow() (uint64, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refresh()
+	switch b.state {
+	case BreakerClosed:
+		return b.generation, true
+	case BreakerHalfOpen:
+		if b.inflight+b.succeeded < b.trials {
+			b.inflight++
+			return b.generation, true
+		}
+		return b.generation, false
+	default:
+		return b.generation, false
+	}
+}
+
+// Record counts the outcome of a request. Outcomes of requests admitted
+// before the last state change are ignored, so a slow request from before
+// the breaker opened can neither use up nor decide a half-open trial.
+func (b *Breaker) Record(generation uint64, success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refresh()
+	if generation != b.generation {
+		return
+	}
+
+	switch b.state {
+	case BreakerClosed:
+		bucket := b.bucket()
+		if success {
+			bucket.successes++
+		} else {
+			bucket.failures++
+		}
+		if successes, failures := b.totals(); successes+failures >= b.minRequests &&
+			float64(failures)/float64(successes+failures) >= b.errorRate {
+			b.transition(BreakerOpen)
+		}
+	case BreakerHalfOpen:
+		b.inflight--
+		if !success {
+			b.transition(BreakerOpen)
+			return
+		}
+		b.succeeded++
+		if b.succeeded >= b.trials {
+			b.transition(BreakerClosed)
+		}
+	}
+}
+
+// Cancel releases a request that ended without a meaningful outcome, such
+// as the client disconnecting.
+func (b *Breaker) Cancel(generation uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if generation == b.generation && b.state == BreakerHalfOpen && b.inflight > 0 {
+		b.inflight--
+	}
+}
+
+func (b *Breaker) Trip() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.transition(BreakerOpen)
+}
+
+func (b *Breaker) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.transition(BreakerClosed)
+}
+
+// refresh moves an open breaker to half-open once the cool-down has passed.
+func (b *Breaker) refresh() {
+	if b.state == BreakerOpen && b.now().Sub(b.openedAt) >= b.cooldown {
+		b.transition(BreakerHalfOpen)
+	}
+}
+
+func (b *Breaker) transition(state BreakerState) {
+	from := b.state
+	b.state = state
+	b.inflight = 0
+	b.succeeded = 0
+	b.generation++
+
+	switch state {
+	case BreakerOpen:
+		b.openedAt = b.now()
+	case BreakerClosed:
+		for i := range b.buckets {
+			b.buckets[i] = breakerBucket{}
+		}
+	}
+
+	if from != state && b.onChange != nil {
+		go b.onChange(from, state)
+	}
+}
+
+func (b *Breaker) bucket() *breakerBucket {
+	second := b.now().Unix()
+	bucket := &b.buckets[second%int64(len(b.buckets))]
+	if bucket.second != second {
+		*bucket = breakerBucket{second: second}
+	}
+	return bucket
+}
+
+func (b *Breaker) totals() (int, int) {
+	oldest := b.now().Unix() - int64(len(b.buckets))
+	successes, failures := 0, 0
+	for _, bucket := range b.buckets {
+		if bucket.second > oldest {
+			successes += bucket.successes
+			failures += bucket.failures
+		}
+	}
+	return successes, failures
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Unix(1000, 0)
+	b := NewBreaker(BreakerConfig{Window: 10, ErrorRate: 0.5, MinRequests: 4, Cooldown: 5, HalfOpenTrials: 2})
+	b.now = func() time.Time { return now }
+
+	allow := func() bool {
+		_, ok := b.Allow()
+		return ok
+	}
+
+	gen, _ := b.Allow()
+	b.Record(gen, true)
+	b.Record(gen, false)
+	b.Record(gen, false)
+	if b.State() != BreakerClosed {
+		t.Fatal("breaker should stay closed below the minimum request count")
+	}
+
+	b.Record(gen, false)
+	if b.State() != BreakerOpen || allow() {
+		t.Fatal("breaker should open once the error rate is crossed")
+	}
+
+	now = now.Add(5 * time.Second)
+	if b.State() != BreakerHalfOpen {
+		t.Fatal("breaker should be half-open after the cool-down")
+	}
+	if !allow() || !allow() || allow() {
+		t.Fatal("half-open breaker should allow exactly 2 trials")
+	}
+
+	gen, _ = b.Allow()
+	b.Record(gen, false)
+	if b.State() != BreakerOpen {
+		t.Fatal("a failed trial should reopen the breaker")
+	}
+
+	now = now.Add(5 * time.Second)
+	gen, _ = b.Allow()
+	b.Allow()
+	b.Cancel(gen)
+	if !b.Ready() {
+		t.Fatal("a cancelled trial should be released")
+	}
+	b.Allow()
+	b.Record(gen, true)
+	b.Record(gen, true)
+	if b.State() != BreakerClosed {
+		t.Fatal("successful trials should close the breaker")
+	}
+}
+
+func TestBreakerStaleRecords(t *testing.T) {
+	now := time.Unix(1000, 0)
+	b := NewBreaker(BreakerConfig{Window: 10, ErrorRate: 0.5, MinRequests: 1, Cooldown: 5, HalfOpenTrials: 1})
+	b.now = func() time.Time { return now }
+
+	// A request admitted while closed outlives the breaker opening.
+	stale, _ := b.Allow()
+	gen, _ := b.Allow()
+	b.Record(gen, false)
+
+	now = now.Add(5 * time.Second)
+	trial, ok := b.Allow()
+	if !ok {
+		t.Fatal("half-open breaker should allow a trial")
+	}
+
+	b.Record(stale, false)
+	if b.State() != BreakerHalfOpen {
+		t.Fatal("a stale failure should not reopen the breaker")
+	}
+	b.Record(stale, true)
+	b.Cancel(stale)
+	if b.State() != BreakerHalfOpen || b.Ready() {
+		t.Fatal("a stale outcome should not decide or release the trial")
+	}
+
+	b.Record(trial, true)
+	if b.State() != BreakerClosed {
+		t.Fatal("the trial's success should close the breaker")
+	}
+}
+
+func TestBreakerWindow(t *testing.T) {
+	now := time.Unix(1000, 0)
+	b := NewBreaker(BreakerConfig{Window: 10, ErrorRate: 0.5, MinRequests: 4})
+	b.now = func() time.Time { return now }
+
+	gen, _ := b.Allow()
+	b.Record(gen, false)
+	b.Record(gen, false)
+	b.Record(gen, false)
+
+	now = now.Add(11 * time.Second)
+	b.Record(gen, false)
+	if b.State() != BreakerClosed {
+		t.Fatal("failures outside the window should not count")
+	}
+}
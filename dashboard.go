@@ -3,7 +3,7 @@ package main
 import (
 	"html/template"
 	"net/http"
-	"strconv"
+	"strings"
 
 	"github.com/rs/zerolog/log"
 )
@@ -34,13 +34,29 @@ func (sf *StarFleet) handleDashboardRequestCounter(w http.ResponseWriter, r *htt
 	}
 }
 
+func (sf *StarFleet) handleDashboardQueue(w http.ResponseWriter, r *http.Request) {
+	tmpl, _ := template.ParseFiles("www/queue.html")
+	if err := tmpl.Execute(w, sf.workerPool.QueueStats()); err != nil {
+		http.Error(w, "", http.StatusInternalServerError)
+	}
+}
+
 func (sf *StarFleet) handleDashboardRevive(w http.ResponseWriter, r *http.Request) {
-	path := r.URL.Path
-	numStr := path[len("/dashboard-revive/"):]
-	num, err := strconv.Atoi(numStr)
-	if err != nil {
-		http.Error(w, "Invalid number", http.StatusBadRequest)
+	id := strings.TrimPrefix(r.URL.Path, "/dashboard-revive/")
+	if err := sf.workerPool.Revive(id); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+}
+
+func (sf *StarFleet) handleDashboardDrain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/dashboard-drain/")
+	if _, err := sf.workerPool.Drain(id); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
-	sf.workerPool.Revive(num)
 }
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	errFirstTokenTimeout = errors.New("LLM did not produce a first token in time")
+	errTokenTimeout      = errors.New("LLM stalled between tokens")
+	errGenerationTimeout = errors.New("LLM exceeded the generation time limit")
+)
+
+// watchdog cancels a generation when one of its deadlines expires, and
+// remembers which deadline it was.
+type watchdog struct {
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	timer  *time.Timer
+	total  *time.Timer
+	err    error
+}
+
+func newWatchdog(ctx context.Context, generationTimeout time.Duration) (context.Context, *watchdog) {
+	ctx, cancel := context.WithCancel(ctx)
+	d := &watchdog{cancel: cancel}
+	if generationTimeout > 0 {
+		d.total = time.AfterFunc(generationTimeout, func() {
+			d.expire(fmt.Errorf("%w of %v", errGenerationTimeout, generationTimeout))
+		})
+	}
+	return ctx, d
+}
+
+// arm (re)starts the per-step deadline. A zero timeout disarms it.
+func (d *watchdog) arm(timeout time.Duration, err error) {
+	d.disarm()
+	if timeout <= 0 {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.timer = time.AfterFunc(timeout, func() {
+		d.expire(fmt.Errorf("%w after %v", err, timeout))
+	})
+}
+
+func (d *watchdog) disarm() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+}
+
+func (d *watchdog) stop() {
+	d.disarm()
+	if d.total != nil {
+		d.total.Stop()
+	}
+	d.cancel()
+}
+
+// Err returns the expired deadline, if any.
+func (d *watchdog) Err() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.err
+}
+
+func (d *watchdog) expire(err error) {
+	d.mu.Lock()
+	if d.err == nil {
+		d.err = err
+	}
+	d.mu.Unlock()
+	d.cancel()
+}
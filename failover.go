@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	failoverDefaultAttempts = 3
+	failoverDefaultDeadline = 30
+)
+
+type FailoverConfig struct {
+	Attempts int `json:"attempts,omitempty"`
+	Deadline int `json:"deadline,omitempty"`
+}
+
+func (c *FailoverConfig) defaults() {
+	if c.Attempts <= 0 {
+		c.Attempts = failoverDefaultAttempts
+	}
+	if c.Deadline <= 0 {
+		c.Deadline = failoverDefaultDeadline
+	}
+}
+
+// statusError is returned when a worker answers a prompt with a non-2xx status.
+type statusError struct {
+	status int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("LLM responded with status %d %s", e.status, http.StatusText(e.status))
+}
+
+// canFailover reports whether a prompt error is the worker's fault, so the
+// job may be retried elsewhere. Client errors would fail on any worker.
+func canFailover(err error) bool {
+	if serr, ok := err.(*statusError); ok {
+		return serr.status >= 500
+	}
+	return true
+}
+
+// failover re-enlists a job that failed on worker w before emitting any
+// tokens. It returns false if the job has exhausted its attempts or deadline,
+// or if no other live worker is available.
+func (wp *WorkerPool) failover(job *Job, w *Worker, err error) bool {
+	// The dispatcher counts attempts under the pending lock.
+	wp.pending.mu.Lock()
+	attempt := job.attempt
+	wp.pending.mu.Unlock()
+
+	if attempt >= wp.failoverConfig.Attempts {
+		log.Warn().Str("request id", job.Id).Int("attempt", attempt).Msg("Job has exhausted its failover attempts")
+		return false
+	}
+
+	deadline := time.Duration(wp.failoverConfig.Deadline) * time.Second
+	if time.Since(job.start) > deadline {
+		log.Warn().Str("request id", job.Id).Int("attempt", attempt).Msgf("Job has exceeded its failover deadline of %v", deadline)
+		return false
+	}
+
+	if !wp.live(wp.Workers(), job) {
+		log.Warn().Str("request id", job.Id).Int("attempt", attempt).Msg("No other live worker to fail over to")
+		return false
+	}
+
+	log.
+		Warn().
+		Err(err).
+		Str("request id", job.Id).
+		Str("failed worker host", w.host).
+		Int("attempt", attempt+1).
+		Msg("Failing job over to another worker")
+
+	wp.requeue(job)
+	return true
+}
+
+// handBack returns a job that has not started from a draining worker to the
+// pool queue. Unlike failover it is not limited by attempts or deadline, and
+// the attempt on the draining worker does not count. It returns false if no
+// other live worker could take the job.
+func (wp *WorkerPool) handBack(job *Job, w *Worker) bool {
+	if !wp.live(wp.Workers(), job) {
+		log.Warn().Str("request id", job.Id).Str("worker host", w.host).Msg("No other live worker to take job from draining worker")
+		return false
+	}
+
+	log.Info().Str("request id", job.Id).Str("worker host", w.host).Msg("Handing job off draining worker")
+
+	wp.pending.mu.Lock()
+	job.attempt--
+	wp.pending.mu.Unlock()
+	wp.requeue(job)
+	return true
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+const fairDefaultWeight = 1
+
+// FairConfig turns on weighted fair queuing across tenants. A job's tenant
+// is read from the claim path if one is set, otherwise from the header, such
+// as an API key. Jobs without either share one anonymous tenant. Free
+// slots rotate across tenants in proportion to their weights rather than
+// going to whoever queued first.
+type FairConfig struct {
+	ClaimPath     []string       `json:"claimPath,omitempty"`
+	Header        string         `json:"header,omitempty"`
+	Weights       map[string]int `json:"weights,omitempty"`
+	DefaultWeight int            `json:"defaultWeight,omitempty"`
+}
+
+func (c *FairConfig) defaults() {
+	if c.DefaultWeight <= 0 {
+		c.DefaultWeight = fairDefaultWeight
+	}
+}
+
+// fairQueue tags jobs for start-time fair queuing. Each tenant's jobs are
+// spaced 1/weight apart in virtual time, starting no earlier than the tag of
+// the last dispatched job, and jobs are dispatched in tag order.
+type fairQueue struct {
+	config  FairConfig
+	enabled bool
+	vtime   float64
+	finish  map[string]float64
+}
+
+func newFairQueue(config FairConfig) *fairQueue {
+	config.defaults()
+	return &fairQueue{
+		config:  config,
+		enabled: config.ClaimPath != nil || config.Header != "",
+		finish:  make(map[string]float64),
+	}
+}
+
+// tenant returns the job's tenant and how it is shown in stats. Tenants
+// identified by a header are hashed so that API keys are not exposed.
+func (f *fairQueue) tenant(job *Job) (string, string) {
+	if f.config.ClaimPath != nil {
+		if claim, ok := GetJsonPath(job.Claims, f.config.ClaimPath); ok && claim != nil {
+			tenant := fmt.Sprint(claim)
+			return tenant, tenant
+		}
+		// Clients can send any header, so it is not trusted once tenants
+		// come from claims.
+		return "", ""
+	}
+	if f.config.Header != "" && job.Header != nil {
+		if key := job.Header.Get(f.config.Header); key != "" {
+			return key, fmt.Sprintf("key:%08x", uint32(xxhash.Sum64String(key)))
+		}
+	}
+	return "", ""
+}
+
+func (f *fairQueue) weight(tenant string) float64 {
+	if weight, ok := f.config.Weights[tenant]; ok && weight > 0 {
+		return float64(weight)
+	}
+	return float64(f.config.DefaultWeight)
+}
+
+// tag gives a newly queued job its virtual start time. Callers hold
+// wp.pending.mu.
+func (f *fairQueue) tag(job *Job) {
+	if !f.enabled {
+		return
+	}
+	job.tenant, job.tenantLabel = f.tenant(job)
+
+	start := f.finish[job.tenant]
+	if start < f.vtime {
+		start = f.vtime
+	}
+	job.tag = start
+	f.finish[job.tenant] = start + 1/f.weight(job.tenant)
+}
+
+// dispatched advances virtual time to the tag of a job that was bound to a
+// worker, and forgets tenants that have fallen behind it.
+func (f *fairQueue) dispatched(job *Job) {
+	if job.tag <= f.vtime {
+		return
+	}
+	f.vtime = job.tag
+	for tenant, finish := range f.finish {
+		if finish <= f.vtime {
+			delete(f.finish, tenant)
+		}
+	}
+}
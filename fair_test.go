@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFairQueueTags(t *testing.T) {
+	fair := newFairQueue(FairConfig{ClaimPath: []string{"tenant"}, Weights: map[string]int{"b": 2}})
+
+	newJob := func(tenant string) *Job {
+		job := NewJob(context.Background(), tenant, nil)
+		job.Claims = map[string]any{"tenant": tenant}
+		fair.tag(job)
+		return job
+	}
+
+	var tags []float64
+	for _, tenant := range []string{"a", "a", "a", "b", "b", "b"} {
+		tags = append(tags, newJob(tenant).tag)
+	}
+	if expected := []float64{0, 1, 2, 0, 0.5, 1}; !reflect.DeepEqual(tags, expected) {
+		t.Fatalf("expected tags %v, got %v", expected, tags)
+	}
+
+	// A tenant that shows up late starts at the current virtual time rather
+	// than jumping ahead of everyone.
+	fair.dispatched(&Job{tag: 2})
+	if job := newJob("c"); job.tag != 2 {
+		t.Fatalf("expected a new tenant to start at the virtual time, got %v", job.tag)
+	}
+
+	if tenant, label := newFairQueue(FairConfig{Header: "X-Api-Key"}).tenant(&Job{Header: http.Header{"X-Api-Key": {"secret"}}}); tenant != "secret" || strings.Contains(label, "secret") {
+		t.Fatalf("expected API keys to be hidden in stats, got %q", label)
+	}
+
+	// A client without the claim cannot pick a fresh tenant per request.
+	spoofed := newFairQueue(FairConfig{ClaimPath: []string{"tenant"}, Header: "X-Api-Key"})
+	for _, key := range []string{"x", "y", "z"} {
+		if tenant, _ := spoofed.tenant(&Job{Header: http.Header{"X-Api-Key": {key}}}); tenant != "" {
+			t.Fatalf("expected the header to be ignored once a claim path is set, got %q", tenant)
+		}
+	}
+}
+
+func TestFairQueueDispatch(t *testing.T) {
+	var mu sync.Mutex
+	var order []string
+	release := make(chan struct{})
+	sim := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		order = append(order, string(body))
+		mu.Unlock()
+		<-release
+	}))
+	defer sim.Close()
+
+	sf := New(StarFleetConfig{
+		WorkerPoolConfig: WorkerPoolConfig{
+			Workers: []WorkerConfig{{Host: sim.URL, Capacity: 1, FirstTokenTimeout: -1}},
+			Fair:    FairConfig{Header: "X-Tenant", Weights: map[string]int{"b": 2}},
+		},
+	})
+	workerPool := sf.workerPool
+	go workerPool.Run()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	enlist := func(id string, tenant string) {
+		job := NewJob(ctx, id, []byte(id))
+		job.Header = http.Header{"X-Tenant": {tenant}}
+		if err := workerPool.Enlist(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	enlist("running", "a")
+	waitFor(t, 5*time.Second, func() bool { return workerPool.Pending() == 0 })
+	for _, id := range []string{"a1", "a2", "a3"} {
+		enlist(id, "a")
+	}
+	for _, id := range []string{"b1", "b2", "b3"} {
+		enlist(id, "b")
+	}
+
+	stats := workerPool.QueueStats()
+	if stats.Size != 6 || len(stats.Tenants) != 2 {
+		t.Fatalf("expected 6 jobs queued across 2 tenants, got %+v", stats)
+	}
+	for tenant, queued := range stats.Tenants {
+		if queued != 3 {
+			t.Fatalf("expected 3 jobs queued for %s, got %d", tenant, queued)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	sf.handleDashboardQueue(w, httptest.NewRequest("GET", "/dashboard-queue", nil))
+	if !strings.Contains(w.Body.String(), "key:") {
+		t.Fatalf("expected the dashboard to list tenants, got %q", w.Body.String())
+	}
+
+	close(release)
+	waitFor(t, 5*time.Second, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(order) == 7
+	})
+
+	if expected := []string{"running", "b1", "b2", "a1", "b3", "a2", "a3"}; !reflect.DeepEqual(order, expected) {
+		t.Fatalf("expected slots to rotate by weight %v, got %v", expected, order)
+	}
+}
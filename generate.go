@@ -1,9 +1,12 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 
 	"github.com/rs/zerolog/log"
 )
@@ -19,10 +22,24 @@ func (sf *StarFleet) handleGenerate(w http.ResponseWriter, r *http.Request) {
 
 	ctx := r.Context()
 	job := NewJob(ctx, id, payload)
+	job.Model = getModel(r, payload)
+	job.Header = r.Header
+	job.Claims = ClaimsFromContext(ctx)
+	job.Selector = SelectorFromContext(ctx)
 	//defer job.Close()
 
 	log.Info().Str("request id", id).Msg("Beginning generation job")
-	if err := sf.workerPool.Enlist(job); err != nil {
+	if err := sf.workerPool.Enlist(job); errors.Is(err, errUnknownModel) {
+		LogHttpErr(w, id, fmt.Sprintf("Unknown model %q", job.Model), err, http.StatusNotFound)
+		return
+	} else if errors.Is(err, errPromptTooLarge) {
+		LogHttpErr(w, id, fmt.Sprintf("Prompt of about %d tokens is too large for any worker's context window", job.Tokens), err, http.StatusRequestEntityTooLarge)
+		return
+	} else if errors.Is(err, errQueueFull) {
+		sf.retryAfter(w, job)
+		LogHttpErr(w, id, "Too many requests queued", err, http.StatusTooManyRequests)
+		return
+	} else if err != nil {
 		LogHttpErr(w, id, "Could not connect to LLM", err, http.StatusServiceUnavailable)
 		return
 	}
@@ -33,18 +50,73 @@ func (sf *StarFleet) handleGenerate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	started := false
+	write := func(token string) {
+		if !started {
+			started = true
+			if worker := job.Winner(); worker != nil && worker.group != "" {
+				w.Header().Set("X-Worker-Group", worker.group)
+				log.Info().Str("request id", id).Str("group", worker.group).Msg("Serving request from worker group")
+			}
+		}
+		fmt.Fprint(w, token)
+		flusher.Flush()
+	}
+
+	fail := func(err error) {
+		if started {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		} else if errors.Is(err, errQueueTimeout) {
+			sf.retryAfter(w, job)
+			LogHttpErr(w, id, "Timed out waiting for a worker", err, http.StatusServiceUnavailable)
+		} else if errors.Is(err, errNoLiveWorker) {
+			LogHttpErr(w, id, "Could not connect to LLM", err, http.StatusServiceUnavailable)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-job.Ctx.Done():
+			// The job may finish before its last tokens have been written,
+			// or right after reporting an error.
+			for len(job.Output) > 0 {
+				write(<-job.Output)
+			}
+			select {
+			case err := <-job.Err:
+				fail(err)
+			default:
+			}
 			return
 		case token := <-job.Output:
-			fmt.Fprint(w, token)
-			flusher.Flush()
+			write(token)
 		case err := <-job.Err:
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			fail(err)
 			return
 		}
 	}
 }
+
+func (sf *StarFleet) retryAfter(w http.ResponseWriter, job *Job) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(sf.workerPool.RetryAfter(job).Seconds())))
+}
+
+// getModel reads the requested model from the X-Model header, falling back
+// to the "model" field of a JSON payload.
+func getModel(r *http.Request, payload []byte) string {
+	if model := r.Header.Get("X-Model"); model != "" {
+		return model
+	}
+
+	var body struct {
+		Model string `json:"model"`
+	}
+	if err := json.Unmarshal(payload, &body); err != nil {
+		return ""
+	}
+	return body.Model
+}
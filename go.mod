@@ -3,12 +3,15 @@ module github.com/quantanotes/starfleet
 go 1.20
 
 require (
-	github.com/cespare/xxhash/v2 v2.2.0 // indirect
-	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
-	github.com/golang-jwt/jwt/v5 v5.0.0 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f
+	github.com/golang-jwt/jwt/v5 v5.0.0
+	github.com/redis/go-redis/v9 v9.0.5
+	github.com/rs/zerolog v1.29.1
+)
+
+require (
 	github.com/mattn/go-colorable v0.1.12 // indirect
 	github.com/mattn/go-isatty v0.0.14 // indirect
-	github.com/redis/go-redis/v9 v9.0.5 // indirect
-	github.com/rs/zerolog v1.29.1 // indirect
 	golang.org/x/sys v0.0.0-20210927094055-39ccf1dd6fa6 // indirect
 )
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+var errUnknownGroup = errors.New("unknown worker group")
+
+// WorkerGroupConfig names a group of workers. Groups are listed in fallback
+// order. A job waits in the pool queue for its first group with a live
+// worker, and only overflows to the next group once MaxQueueDepth jobs are
+// ahead of it or it has waited MaxWait milliseconds.
+type WorkerGroupConfig struct {
+	Name          string `json:"name"`
+	MaxQueueDepth int    `json:"maxQueueDepth,omitempty"`
+	MaxWait       int    `json:"maxWait,omitempty"`
+}
+
+// holds reports whether a job that has position jobs ahead of it and has
+// waited for waited should keep waiting for the group.
+func (g WorkerGroupConfig) holds(position int, waited time.Duration) bool {
+	if g.MaxQueueDepth > 0 && position >= g.MaxQueueDepth {
+		return false
+	}
+	if g.MaxWait > 0 && waited >= time.Duration(g.MaxWait)*time.Millisecond {
+		return false
+	}
+	return true
+}
+
+// pickWorker walks the fallback chain and picks a worker with a free slot
+// from the first group that has one, unless an earlier group with live but
+// busy workers still holds the job. It returns nil if the job has to wait.
+// held counts the jobs a dispatch pass has already left waiting for a busy
+// worker, see liveBalancer; it may be nil outside of dispatch.
+func (wp *WorkerPool) pickWorker(workers []*Worker, job *Job, position int, held map[*Worker]int) *Worker {
+	var waited time.Duration
+	if job != nil {
+		waited = time.Since(job.start)
+	}
+
+	for _, group := range wp.groups {
+		var live, free []*Worker
+		for _, worker := range workers {
+			if worker.group != group.Name || !worker.Available() {
+				continue
+			}
+			if job != nil && (job.hasTried(worker) || !wp.eligible(worker, job)) {
+				continue
+			}
+			live = append(live, worker)
+			if worker.hasSlot() {
+				free = append(free, worker)
+			}
+		}
+
+		if balancer, ok := wp.balancer.(liveBalancer); ok && len(live) > 0 {
+			if worker := balancer.PickLive(live, job, held); worker != nil {
+				return worker
+			}
+		} else if len(free) > 0 {
+			return wp.balancer.Pick(free, job)
+		}
+		if len(live) > 0 && group.holds(position, waited) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (wp *WorkerPool) hasGroup(name string) bool {
+	for _, group := range wp.groups {
+		if group.Name == name {
+			return true
+		}
+	}
+	return false
+}
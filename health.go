@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const (
+	healthDefaultEndpoint         = "/"
+	healthDefaultMethod           = "GET"
+	healthDefaultTimeout          = 5
+	healthDefaultStatus           = http.StatusOK
+	healthDefaultSuccessThreshold = 1
+	healthDefaultFailureThreshold = 1
+	healthMaxBodySize             = 1024 * 1024
+)
+
+type HealthConfig struct {
+	Endpoint         string            `json:"endpoint,omitempty"`
+	Method           string            `json:"method,omitempty"`
+	Headers          map[string]string `json:"headers,omitempty"`
+	Timeout          int               `json:"timeout,omitempty"`
+	ExpectStatus     int               `json:"expectStatus,omitempty"`
+	ExpectBody       string            `json:"expectBody,omitempty"`
+	ExpectJsonPath   []string          `json:"expectJsonPath,omitempty"`
+	ExpectJsonValue  any               `json:"expectJsonValue,omitempty"`
+	SuccessThreshold int               `json:"successThreshold,omitempty"`
+	FailureThreshold int               `json:"failureThreshold,omitempty"`
+}
+
+func (c *HealthConfig) defaults() {
+	if c.Endpoint == "" {
+		c.Endpoint = healthDefaultEndpoint
+	}
+	if c.Method == "" {
+		c.Method = healthDefaultMethod
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = healthDefaultTimeout
+	}
+	if c.ExpectStatus == 0 {
+		c.ExpectStatus = healthDefaultStatus
+	}
+	if c.SuccessThreshold <= 0 {
+		c.SuccessThreshold = healthDefaultSuccessThreshold
+	}
+	if c.FailureThreshold <= 0 {
+		c.FailureThreshold = healthDefaultFailureThreshold
+	}
+}
+
+// HealthCheck probes a worker and debounces the results, so that the worker
+// only changes state after enough consecutive successes or failures.
+type HealthCheck struct {
+	config HealthConfig
+
+	successes int
+	failures  int
+}
+
+func NewHealthCheck(config HealthConfig) *HealthCheck {
+	config.defaults()
+	return &HealthCheck{
+		config: config,
+	}
+}
+
+// Check performs a single probe against host.
+func (h *HealthCheck) Check(client *http.Client, host string, headers map[string]string) error {
+	path, err := url.JoinPath(host, h.config.Endpoint)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(h.config.Timeout)*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, h.config.Method, path, nil)
+	if err != nil {
+		return err
+	}
+
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	for k, v := range h.config.Headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != h.config.ExpectStatus {
+		return fmt.Errorf("expected status %d, got %d", h.config.ExpectStatus, res.StatusCode)
+	}
+
+	if h.config.ExpectBody == "" && h.config.ExpectJsonPath == nil {
+		return nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, healthMaxBodySize))
+	if err != nil {
+		return err
+	}
+
+	if h.config.ExpectBody != "" && !strings.Contains(string(body), h.config.ExpectBody) {
+		return fmt.Errorf("expected body to contain %q", h.config.ExpectBody)
+	}
+
+	if h.config.ExpectJsonPath != nil {
+		var data any
+		if err := json.Unmarshal(body, &data); err != nil {
+			return err
+		}
+
+		value, ok := GetJsonPath(data, h.config.ExpectJsonPath)
+		if !ok {
+			return fmt.Errorf("expected json field %s", strings.Join(h.config.ExpectJsonPath, "."))
+		}
+
+		if h.config.ExpectJsonValue != nil && !reflect.DeepEqual(value, h.config.ExpectJsonValue) {
+			return fmt.Errorf("expected json field %s to be %v, got %v", strings.Join(h.config.ExpectJsonPath, "."), h.config.ExpectJsonValue, value)
+		}
+	}
+
+	return nil
+}
+
+// Observe records a probe result and returns whether the worker should be
+// considered alive, given whether it is alive now.
+func (h *HealthCheck) Observe(alive bool, healthy bool) bool {
+	if healthy {
+		h.successes++
+		h.failures = 0
+	} else {
+		h.failures++
+		h.successes = 0
+	}
+
+	if alive && h.failures >= h.config.FailureThreshold {
+		return false
+	}
+	if !alive && h.successes >= h.config.SuccessThreshold {
+		return true
+	}
+	return alive
+}
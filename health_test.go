@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealthCheck(t *testing.T) {
+	status := "loading"
+	sim := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" || r.Header.Get("X-Probe") != "1" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `{"model":{"status":"%s","slots":2}}`, status)
+	}))
+	defer sim.Close()
+
+	check := NewHealthCheck(HealthConfig{
+		Endpoint:        "/health",
+		Headers:         map[string]string{"X-Probe": "1"},
+		ExpectJsonPath:  []string{"model", "status"},
+		ExpectJsonValue: "ok",
+	})
+	if err := check.Check(http.DefaultClient, sim.URL, nil); err == nil {
+		t.Fatal("expected a loading model to fail the health check")
+	}
+	status = "ok"
+	if err := check.Check(http.DefaultClient, sim.URL, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	check = NewHealthCheck(HealthConfig{Endpoint: "/health", ExpectBody: `"slots":2`})
+	if err := check.Check(http.DefaultClient, sim.URL, map[string]string{"X-Probe": "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := check.Check(http.DefaultClient, sim.URL, nil); err == nil {
+		t.Fatal("expected a 404 to fail the health check")
+	}
+}
+
+func TestHealthCheckThresholds(t *testing.T) {
+	check := NewHealthCheck(HealthConfig{SuccessThreshold: 2, FailureThreshold: 3})
+
+	alive := true
+	for i, healthy := range []bool{false, false, true, false, false} {
+		if alive = check.Observe(alive, healthy); !alive {
+			t.Fatalf("worker should not die on probe %d without 3 consecutive failures", i)
+		}
+	}
+	if alive = check.Observe(alive, false); alive {
+		t.Fatal("worker should die after 3 consecutive failures")
+	}
+	if alive = check.Observe(alive, true); alive {
+		t.Fatal("worker should not revive after a single success")
+	}
+	if alive = check.Observe(alive, true); !alive {
+		t.Fatal("worker should revive after 2 consecutive successes")
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// HedgeConfig sends a second copy of a job to another worker when the first
+// has not produced a token within Delay milliseconds. Hedging is off unless
+// Delay is set. If a header or claim path is given, only jobs that carry the
+// header or a true claim are hedged, otherwise every job is.
+type HedgeConfig struct {
+	Delay     int      `json:"delay,omitempty"`
+	Header    string   `json:"header,omitempty"`
+	ClaimPath []string `json:"claimPath,omitempty"`
+}
+
+func (c HedgeConfig) applies(job *Job) bool {
+	if c.Delay <= 0 {
+		return false
+	}
+	if c.Header == "" && c.ClaimPath == nil {
+		return true
+	}
+	if c.Header != "" && job.Header != nil && job.Header.Get(c.Header) != "" {
+		return true
+	}
+	return c.ClaimPath != nil && IsJsonPath(job.Claims, c.ClaimPath)
+}
+
+// hedge waits out the hedge delay and, if no worker has started streaming
+// the job by then, asks the dispatcher for one more worker.
+func (wp *WorkerPool) hedge(job *Job) {
+	timer := time.NewTimer(time.Duration(wp.hedgeConfig.Delay) * time.Millisecond)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-job.Ctx.Done():
+		return
+	}
+
+	if job.Winner() != nil {
+		return
+	}
+
+	wp.pending.mu.Lock()
+	wp.pending.hedges = append(wp.pending.hedges, job)
+	wp.pending.mu.Unlock()
+	wp.notify()
+}
+
+// dispatchHedges submits waiting hedges to free workers. A hedge is a second
+// copy of a job that already has a worker, so it only takes a slot that no
+// queued job could use. Callers hold wp.pending.mu.
+func (wp *WorkerPool) dispatchHedges(workers []*Worker) {
+	waiting := wp.pending.hedges[:0]
+	for _, job := range wp.pending.hedges {
+		if job.Ctx.Err() != nil || job.Winner() != nil {
+			continue
+		}
+
+		worker := wp.pickWorker(workers, job, 0, nil)
+		if worker == nil || wp.wanted(worker) {
+			waiting = append(waiting, job)
+			continue
+		}
+		if !job.startHedge(worker) {
+			continue
+		}
+
+		log.Info().Str("request id", job.Id).Str("worker host", worker.host).Msg("Hedging job to another worker")
+
+		atomic.AddInt32(&worker.hedgesIssued, 1)
+		worker.Submit(job)
+	}
+	wp.pending.hedges = waiting
+}
+
+// wanted reports whether any queued job could run on worker. Callers hold
+// wp.pending.mu.
+func (wp *WorkerPool) wanted(worker *Worker) bool {
+	for _, job := range wp.pending.jobs {
+		if job.ReqCtx.Err() == nil && !job.hasTried(worker) && wp.eligible(worker, job) {
+			return true
+		}
+	}
+	return false
+}
+
+// startHedge marks w as the job's hedge. It returns false if the job already
+// has a winner or has been hedged.
+func (j *Job) startHedge(w *Worker) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.winner != nil || j.hedge != nil {
+		return false
+	}
+	j.hedge = w
+	return true
+}
+
+func (j *Job) isHedge(w *Worker) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.hedge == w
+}
@@ -1,15 +1,46 @@
 package main
 
-import "context"
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
 
 type Job struct {
-	ReqCtx  context.Context
-	Ctx     context.Context
-	Id      string
-	Payload []byte
-	Output  chan string
-	Err     chan error
-	Finish  context.CancelFunc
+	ReqCtx   context.Context
+	Ctx      context.Context
+	Id       string
+	Model    string
+	Header   http.Header
+	Claims   map[string]any
+	Selector map[string]string
+	Tokens   int
+	Payload  []byte
+	Output   chan string
+	Err      chan error
+	Finish   context.CancelFunc
+
+	start       time.Time
+	seq         uint64
+	priority    int
+	tag         float64
+	tenant      string
+	tenantLabel string
+	attempt     int
+	tried       map[*Worker]bool
+	onFail      func(job *Job, w *Worker, err error) bool
+	onDrain     func(job *Job, w *Worker) bool
+	started     chan struct{}
+	startOnce   sync.Once
+
+	// A job may run on several workers at once when hedged. The first
+	// attempt to emit a token wins and the others are cancelled.
+	mu      sync.Mutex
+	live    int
+	winner  *Worker
+	hedge   *Worker
+	cancels map[*Worker]context.CancelFunc
 }
 
 func NewJob(reqCtx context.Context, id string, payload []byte) *Job {
@@ -22,6 +53,11 @@ func NewJob(reqCtx context.Context, id string, payload []byte) *Job {
 		Output:  make(chan string, 100),
 		Err:     make(chan error, 10),
 		Finish:  cancel,
+		start:   time.Now(),
+		attempt: 0,
+		tried:   make(map[*Worker]bool),
+		started: make(chan struct{}),
+		cancels: make(map[*Worker]context.CancelFunc),
 	}
 }
 
@@ -29,3 +65,103 @@ func (j *Job) Close() {
 	close(j.Output)
 	close(j.Err)
 }
+
+// Failover hands a job that failed on w before emitting any tokens back to
+// its pool. It returns true if the job now belongs to another worker.
+func (j *Job) Failover(w *Worker, err error) bool {
+	if j.onFail == nil || !canFailover(err) {
+		return false
+	}
+	return j.onFail(j, w, err)
+}
+
+// Started is closed once a worker has granted the job a slot.
+func (j *Job) Started() <-chan struct{} {
+	return j.started
+}
+
+func (j *Job) begin() {
+	j.startOnce.Do(func() { close(j.started) })
+}
+
+func (j *Job) hasTried(w *Worker) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.tried[w]
+}
+
+// bound reports whether the job has ever been handed to a worker.
+func (j *Job) bound() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return len(j.tried) > 0
+}
+
+// enter records that w has been handed the job.
+func (j *Job) enter(w *Worker) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.tried[w] = true
+	j.live++
+}
+
+// leave undoes enter for a worker that never took the job.
+func (j *Job) leave() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.live--
+}
+
+// attach registers the cancel func of w's attempt. It returns false if
+// another attempt has already won the job.
+func (j *Job) attach(w *Worker, cancel context.CancelFunc) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.winner != nil && j.winner != w {
+		return false
+	}
+	j.cancels[w] = cancel
+	return true
+}
+
+// claim is called before an attempt emits its first token. The first attempt
+// to claim the job wins it and cancels the others.
+func (j *Job) claim(w *Worker) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.winner == nil {
+		j.winner = w
+		for other, cancel := range j.cancels {
+			if other != w {
+				cancel()
+			}
+		}
+	}
+	return j.winner == w
+}
+
+func (j *Job) lost(w *Worker) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.winner != nil && j.winner != w
+}
+
+// detach ends w's attempt. It returns true if w's outcome decides the job,
+// either because it won or because no other attempt is left.
+func (j *Job) detach(w *Worker) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.live--
+	delete(j.cancels, w)
+	if j.winner != nil {
+		return j.winner == w
+	}
+	return j.live <= 0
+}
+
+// Winner returns the worker streaming the job, or nil before the first token.
+func (j *Job) Winner() *Worker {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.winner
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const poolDispatchInterval = 100 * time.Millisecond
+
+// pendingQueue holds the jobs that have not been bound to a worker yet. Jobs
+// are only bound once a worker has a free slot for them, so they never get
+// stuck behind a busy or dead worker.
+type pendingQueue struct {
+	mu   sync.Mutex
+	jobs []*Job
+	seq  uint64
+	fair *fairQueue
+	wake chan struct{}
+
+	rejected int
+	expired  int
+
+	// hedges holds jobs waiting for a worker for their hedge.
+	hedges []*Job
+
+	// byId holds every job enlisted with a request id until it ends, so
+	// that clients can follow it through the queue.
+	byId map[string]*Job
+}
+
+func newPendingQueue(config FairConfig) *pendingQueue {
+	return &pendingQueue{
+		fair: newFairQueue(config),
+		wake: make(chan struct{}, 1),
+		byId: make(map[string]*Job),
+	}
+}
+
+// enqueue adds a job to the pool queue behind the jobs of its class, unless
+// the queue is full.
+func (wp *WorkerPool) enqueue(job *Job) error {
+	wp.pending.mu.Lock()
+	if err := wp.admit(job); err != nil {
+		wp.pending.rejected++
+		wp.pending.mu.Unlock()
+		log.Warn().Err(err).Str("request id", job.Id).Msg("Rejecting job from full queue")
+		return err
+	}
+	wp.pending.seq++
+	job.seq = wp.pending.seq
+	wp.pending.fair.tag(job)
+	wp.pending.jobs = append(wp.pending.jobs, job)
+	if job.Id != "" {
+		wp.pending.byId[job.Id] = job
+		go wp.forget(job)
+	}
+	wp.pending.mu.Unlock()
+	wp.notify()
+	return nil
+}
+
+func (wp *WorkerPool) forget(job *Job) {
+	<-job.Ctx.Done()
+	wp.pending.mu.Lock()
+	defer wp.pending.mu.Unlock()
+	if wp.pending.byId[job.Id] == job {
+		delete(wp.pending.byId, job.Id)
+	}
+}
+
+// Lookup returns the unfinished job with the given request id, if any.
+func (wp *WorkerPool) Lookup(id string) *Job {
+	wp.pending.mu.Lock()
+	defer wp.pending.mu.Unlock()
+	return wp.pending.byId[id]
+}
+
+// requeue puts a job that failed on a worker back in the pool queue, where
+// it keeps its original place.
+func (wp *WorkerPool) requeue(job *Job) {
+	wp.pending.mu.Lock()
+	wp.pending.jobs = append(wp.pending.jobs, job)
+	wp.pending.mu.Unlock()
+	wp.notify()
+}
+
+// notify wakes the dispatcher, for instance when a slot has been freed.
+func (wp *WorkerPool) notify() {
+	select {
+	case wp.pending.wake <- struct{}{}:
+	default:
+	}
+}
+
+// dispatcher binds pending jobs whenever it is notified, and periodically to
+// pick up workers that came back to life or had their capacity raised.
+func (wp *WorkerPool) dispatcher() {
+	ticker := time.NewTicker(poolDispatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-wp.pending.wake:
+		case now := <-ticker.C:
+			wp.observe(now)
+		}
+		wp.dispatch()
+	}
+}
+
+func (wp *WorkerPool) dispatch() {
+	wp.pending.mu.Lock()
+	defer wp.pending.mu.Unlock()
+
+	now := time.Now()
+	workers := wp.Workers()
+	held := make(map[*Worker]int)
+	jobs := wp.ordered()
+	waiting := jobs[:0]
+	for _, job := range jobs {
+		if job.ReqCtx.Err() != nil {
+			continue
+		} else if wp.expired(job, now) {
+			log.Warn().Str("request id", job.Id).Msg("Job waited too long in queue")
+			wp.pending.expired++
+			job.Err <- errQueueTimeout
+			job.Finish()
+		} else if !wp.live(workers, job) {
+			log.Warn().Str("request id", job.Id).Msg("No live worker left for queued job")
+			job.Err <- errNoLiveWorker
+			job.Finish()
+		} else if worker := wp.pickWorker(workers, job, len(waiting), held); worker == nil || !wp.bind(job, worker) {
+			waiting = append(waiting, job)
+		} else {
+			wp.pending.fair.dispatched(job)
+		}
+	}
+	wp.pending.jobs = waiting
+
+	wp.dispatchHedges(workers)
+}
+
+// ordered sorts the pending jobs by their aged priority class, then by their
+// fair queuing tag, then by arrival. Callers hold wp.pending.mu.
+func (wp *WorkerPool) ordered() []*Job {
+	now := time.Now()
+	jobs := wp.pending.jobs
+	sort.SliceStable(jobs, func(i, j int) bool {
+		ri, rj := wp.priority.rank(jobs[i], now), wp.priority.rank(jobs[j], now)
+		if ri != rj {
+			return ri < rj
+		}
+		if jobs[i].tag != jobs[j].tag {
+			return jobs[i].tag < jobs[j].tag
+		}
+		return jobs[i].seq < jobs[j].seq
+	})
+	return jobs
+}
+
+// bind hands a pending job to a worker with a free slot. It returns false if
+// the job is still pending. Callers hold wp.pending.mu.
+func (wp *WorkerPool) bind(job *Job, worker *Worker) bool {
+	if !wp.assign(job, worker) {
+		// Either the request ended or the worker was removed in the meantime.
+		return job.ReqCtx.Err() != nil
+	}
+	if job.attempt == 1 && wp.hedgeConfig.applies(job) {
+		go wp.hedge(job)
+	}
+	return true
+}
+
+// live reports whether any worker that has not failed the job could run it.
+func (wp *WorkerPool) live(workers []*Worker, job *Job) bool {
+	for _, worker := range workers {
+		if worker.Available() && !job.hasTried(worker) && wp.eligible(worker, job) {
+			return true
+		}
+	}
+	return false
+}
+
+// Position returns the number of jobs that will be dispatched before the
+// pending job with the given id.
+func (wp *WorkerPool) Position(id string) (int, bool) {
+	return wp.position(func(job *Job) bool { return job.Id == id })
+}
+
+func (wp *WorkerPool) position(match func(job *Job) bool) (int, bool) {
+	wp.pending.mu.Lock()
+	defer wp.pending.mu.Unlock()
+
+	position := 0
+	for _, job := range wp.ordered() {
+		if job.ReqCtx.Err() != nil {
+			continue
+		}
+		if match(job) {
+			return position, true
+		}
+		position++
+	}
+	return 0, false
+}
+
+// observe samples the number of jobs the pool has finished so far.
+func (wp *WorkerPool) observe(now time.Time) {
+	finished := 0
+	for _, worker := range wp.Workers() {
+		finished += int(atomic.LoadInt32(&worker.finished))
+	}
+	wp.throughput.observe(now, finished)
+}
+
+// EstimateWait guesses how long a job with ahead jobs in front of it will
+// wait for a slot, from the pool's recent throughput or, before any job has
+// finished, from the average request time of the workers that could run it.
+// It returns 0 while there is nothing to go on.
+func (wp *WorkerPool) EstimateWait(job *Job, ahead int) time.Duration {
+	if rate := wp.throughput.rate(); rate > 0 {
+		return time.Duration(float64(ahead+1) / rate * float64(time.Second))
+	}
+
+	rate := 0.0
+	for _, worker := range wp.Workers() {
+		if !worker.Available() || !wp.eligible(worker, job) {
+			continue
+		}
+		if avg := atomic.LoadInt64(&worker.avgReqTime); avg > 0 {
+			rate += float64(worker.queue.Capacity()) / float64(avg)
+		}
+	}
+	if rate == 0 {
+		return 0
+	}
+	return time.Duration(float64(ahead+1)/rate) * time.Millisecond
+}
+
+// Pending is the number of jobs waiting for a worker.
+func (wp *WorkerPool) Pending() int {
+	wp.pending.mu.Lock()
+	defer wp.pending.mu.Unlock()
+	return len(wp.pending.jobs)
+}
+
+// QueueStats reports the jobs waiting in the pool queue, by tenant when fair
+// queuing is on.
+func (wp *WorkerPool) QueueStats() QueueStats {
+	stats := QueueStats{Tenants: make(map[string]int)}
+	for _, worker := range wp.Workers() {
+		stats.Released += worker.queue.Stats().Released
+	}
+
+	wp.pending.mu.Lock()
+	defer wp.pending.mu.Unlock()
+	stats.Rejected = wp.pending.rejected
+	stats.Expired = wp.pending.expired
+	for _, job := range wp.pending.jobs {
+		if job.ReqCtx.Err() != nil {
+			continue
+		}
+		stats.Size++
+		if wp.pending.fair.enabled {
+			stats.Tenants[job.tenantLabel]++
+		}
+	}
+	return stats
+}
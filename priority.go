@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	priorityInteractive = "interactive"
+	priorityStandard    = "standard"
+	priorityBatch       = "batch"
+
+	priorityDefaultAging = 30
+)
+
+// PriorityConfig sorts queued jobs into classes, listed from highest to
+// lowest. A job's class is read from the claim path if one is set, otherwise
+// from the header, and falls back to Default. The header is never trusted
+// once a claim path is set, as any client can send it. Every Aging seconds a
+// job waits, it moves up one class so that low classes are never starved.
+type PriorityConfig struct {
+	Classes   []string `json:"classes,omitempty"`
+	Default   string   `json:"default,omitempty"`
+	Header    string   `json:"header,omitempty"`
+	ClaimPath []string `json:"claimPath,omitempty"`
+	Aging     int      `json:"aging,omitempty"`
+}
+
+func (c *PriorityConfig) defaults() {
+	if len(c.Classes) == 0 {
+		c.Classes = []string{priorityInteractive, priorityStandard, priorityBatch}
+	}
+	if c.Default == "" {
+		c.Default = priorityStandard
+	}
+	if c.Aging <= 0 {
+		c.Aging = priorityDefaultAging
+	}
+}
+
+type Priority struct {
+	classes   map[string]int
+	fallback  int
+	header    string
+	claimPath []string
+	aging     time.Duration
+}
+
+func NewPriority(config PriorityConfig) (*Priority, error) {
+	config.defaults()
+
+	p := &Priority{
+		classes:   make(map[string]int, len(config.Classes)),
+		header:    config.Header,
+		claimPath: config.ClaimPath,
+		aging:     time.Duration(config.Aging) * time.Second,
+	}
+	for i, class := range config.Classes {
+		p.classes[class] = i
+	}
+
+	fallback, ok := p.classes[config.Default]
+	if !ok {
+		return nil, fmt.Errorf("default priority class %q is not one of %v", config.Default, config.Classes)
+	}
+	p.fallback = fallback
+
+	return p, nil
+}
+
+// Class returns the index of the job's priority class, 0 being the highest.
+func (p *Priority) Class(job *Job) int {
+	if p.claimPath != nil {
+		if claim, ok := GetJsonPath(job.Claims, p.claimPath); ok {
+			if class, ok := p.classes[fmt.Sprint(claim)]; ok {
+				return class
+			}
+		}
+		return p.fallback
+	}
+	if p.header != "" && job.Header != nil {
+		if class, ok := p.classes[job.Header.Get(p.header)]; ok {
+			return class
+		}
+	}
+	return p.fallback
+}
+
+// rank is the job's class after aging, lower ranks being dispatched first.
+func (p *Priority) rank(job *Job, now time.Time) int {
+	rank := job.priority - int(now.Sub(job.start)/p.aging)
+	if rank < 0 {
+		return 0
+	}
+	return rank
+}
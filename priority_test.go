@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPriorityClass(t *testing.T) {
+	byHeader, err := NewPriority(PriorityConfig{Header: "X-Priority"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	byClaim, err := NewPriority(PriorityConfig{Header: "X-Priority", ClaimPath: []string{"plan", "priority"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		priority *Priority
+		header   string
+		claims   map[string]any
+		class    int
+	}{
+		{"default", byHeader, "", nil, 1},
+		{"header", byHeader, "batch", nil, 2},
+		{"unknown", byHeader, "urgent", nil, 1},
+		{"claim", byClaim, "batch", map[string]any{"plan": map[string]any{"priority": "interactive"}}, 0},
+		{"spoofed", byClaim, "interactive", nil, 1},
+		{"spoofed with claims", byClaim, "interactive", map[string]any{"plan": map[string]any{"name": "free"}}, 1},
+	}
+
+	for _, test := range tests {
+		job := NewJob(context.Background(), test.name, nil)
+		job.Header = http.Header{}
+		job.Header.Set("X-Priority", test.header)
+		job.Claims = test.claims
+		if class := test.priority.Class(job); class != test.class {
+			t.Errorf("%s: expected class %d, got %d", test.name, test.class, class)
+		}
+	}
+
+	job := NewJob(context.Background(), "aged", nil)
+	job.priority = 2
+	if rank := byHeader.rank(job, job.start.Add(40*time.Second)); rank != 1 {
+		t.Fatalf("expected a batch job to be promoted once, got rank %d", rank)
+	}
+	if rank := byHeader.rank(job, job.start.Add(10*time.Minute)); rank != 0 {
+		t.Fatalf("expected aging to stop at the highest class, got rank %d", rank)
+	}
+
+	if _, err := NewPriority(PriorityConfig{Classes: []string{"high", "low"}}); err == nil {
+		t.Fatal("expected an error for a default class that does not exist")
+	}
+}
+
+func TestPriorityDispatch(t *testing.T) {
+	var mu sync.Mutex
+	var order []string
+	release := make(chan struct{})
+	sim := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		order = append(order, string(body))
+		mu.Unlock()
+		<-release
+	}))
+	defer sim.Close()
+
+	workerPool := NewWorkerPool(WorkerPoolConfig{
+		Workers:  []WorkerConfig{{Host: sim.URL, Capacity: 1, FirstTokenTimeout: -1}},
+		Priority: PriorityConfig{Header: "X-Priority"},
+	})
+	go workerPool.Run()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	enlist := func(id string, class string) {
+		job := NewJob(ctx, id, []byte(id))
+		job.Header = http.Header{"X-Priority": {class}}
+		if err := workerPool.Enlist(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	enlist("running", "batch")
+	waitFor(t, 5*time.Second, func() bool { return workerPool.Pending() == 0 })
+	enlist("batch", "batch")
+	enlist("standard", "standard")
+	enlist("interactive", "interactive")
+
+	for id, ahead := range map[string]int{"interactive": 0, "standard": 1, "batch": 2} {
+		if position, ok := workerPool.Position(id); !ok || position != ahead {
+			t.Fatalf("expected %s to have %d ahead, got %d, %v", id, ahead, position, ok)
+		}
+	}
+
+	close(release)
+	waitFor(t, 5*time.Second, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(order) == 4
+	})
+
+	if expected := []string{"running", "interactive", "standard", "batch"}; !reflect.DeepEqual(order, expected) {
+		t.Fatalf("expected jobs dispatched by priority %v, got %v", expected, order)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const processStopGrace = 10 * time.Second
+
+// Process is a model server launched and owned by a worker.
+type Process struct {
+	host    string
+	command string
+	args    []string
+	env     map[string]string
+	workdir string
+
+	mu       sync.Mutex
+	cmd      *exec.Cmd
+	exited   chan struct{}
+	stopping bool
+
+	onExit func(err error)
+}
+
+func NewProcess(config WorkerConfig) *Process {
+	return &Process{
+		host:    config.Host,
+		command: config.Command,
+		args:    config.Args,
+		env:     config.Env,
+		workdir: config.Workdir,
+	}
+}
+
+func (p *Process) Start() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cmd != nil {
+		return fmt.Errorf("process is already running")
+	}
+
+	cmd := exec.Command(p.command, p.args...)
+	cmd.Dir = p.workdir
+	cmd.Env = os.Environ()
+	for k, v := range p.env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	log.Info().Str("host", p.host).Int("pid", cmd.Process.Pid).Msg("Started worker process")
+
+	var pipes sync.WaitGroup
+	pipes.Add(2)
+	go p.pipe(stdout, "stdout", &pipes)
+	go p.pipe(stderr, "stderr", &pipes)
+
+	exited := make(chan struct{})
+	p.cmd = cmd
+	p.exited = exited
+	p.stopping = false
+
+	go func() {
+		pipes.Wait()
+		err := cmd.Wait()
+
+		p.mu.Lock()
+		expected := p.stopping
+		p.cmd = nil
+		p.mu.Unlock()
+
+		close(exited)
+
+		if expected {
+			log.Info().Str("host", p.host).Msg("Worker process stopped")
+			return
+		}
+
+		log.Error().Err(err).Str("host", p.host).Msg("Worker process exited unexpectedly")
+		if p.onExit != nil {
+			p.onExit(err)
+		}
+	}()
+
+	return nil
+}
+
+// Stop interrupts the process and kills it if it has not exited within the
+// grace period. It is a no-op if the process is not running.
+func (p *Process) Stop() {
+	p.mu.Lock()
+	cmd := p.cmd
+	exited := p.exited
+	if cmd == nil {
+		p.mu.Unlock()
+		return
+	}
+	p.stopping = true
+	p.mu.Unlock()
+
+	if err := cmd.Process.Signal(os.Interrupt); err != nil {
+		cmd.Process.Kill()
+	}
+
+	select {
+	case <-exited:
+	case <-time.After(processStopGrace):
+		log.Warn().Str("host", p.host).Msg("Worker process did not stop in time, killing it")
+		cmd.Process.Kill()
+		<-exited
+	}
+}
+
+func (p *Process) Running() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.cmd != nil
+}
+
+func (p *Process) pipe(r io.Reader, stream string, wg *sync.WaitGroup) {
+	defer wg.Done()
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		log.Info().Str("host", p.host).Str("stream", stream).Msg(scanner.Text())
+	}
+}
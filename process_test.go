@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestHelperWorkerProcess(t *testing.T) {
+	addr := os.Getenv("STARFLEET_HELPER_ADDR")
+	if addr == "" {
+		t.Skip("helper process for TestWorkerProcessRestart")
+	}
+	http.ListenAndServe(addr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestWorkerProcessRestart(t *testing.T) {
+	addr := freeAddr(t)
+
+	worker := NewWorker(WorkerConfig{
+		Host:       "http://" + addr,
+		Capacity:   1,
+		CheckAlive: true,
+		Restart:    true,
+		Command:    os.Args[0],
+		Args:       []string{"-test.run=TestHelperWorkerProcess"},
+		Env:        map[string]string{"STARFLEET_HELPER_ADDR": addr},
+	})
+	defer worker.process.Stop()
+
+	if worker.IsAlive() {
+		t.Fatal("worker should not be alive before its process is healthy")
+	}
+
+	go worker.Work()
+	waitFor(t, 10*time.Second, func() bool { return worker.IsAlive() })
+
+	// A restart must not end a drain started by an operator.
+	worker.Drain()
+
+	worker.process.mu.Lock()
+	pid := worker.process.cmd.Process.Pid
+	worker.process.cmd.Process.Kill()
+	worker.process.mu.Unlock()
+
+	waitFor(t, 10*time.Second, func() bool { return !worker.IsAlive() })
+	waitFor(t, 10*time.Second, func() bool { return worker.IsAlive() })
+	if !worker.Draining() {
+		t.Fatal("expected the restarted worker to keep draining")
+	}
+
+	worker.process.mu.Lock()
+	defer worker.process.mu.Unlock()
+	if worker.process.cmd == nil || worker.process.cmd.Process.Pid == pid {
+		t.Fatal("expected the worker process to be relaunched")
+	}
+}
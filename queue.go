@@ -1,6 +1,7 @@
 package main
 
 import (
+	"container/list"
 	"context"
 	"encoding/json"
 	"net/http"
@@ -16,13 +17,22 @@ type QueueItem struct {
 type QueueStats struct {
 	Size     int
 	Released int
+	Rejected int
+	Expired  int
+	Tenants  map[string]int
+}
+
+type queueWaiter struct {
+	ready   chan struct{}
+	granted bool
 }
 
 type Queue struct {
 	queue sync.Map
 	size  int32
 
-	pool     chan struct{}
+	mu       sync.Mutex
+	waiters  *list.List
 	capacity int
 
 	released int32
@@ -32,7 +42,7 @@ type Queue struct {
 func NewQueue(capacity int) Queue {
 	return Queue{
 		queue:    sync.Map{},
-		pool:     make(chan struct{}, capacity),
+		waiters:  list.New(),
 		capacity: capacity,
 		size:     0,
 		released: 0,
@@ -40,7 +50,9 @@ func NewQueue(capacity int) Queue {
 	}
 }
 
-func (q *Queue) Wait(ctx context.Context, id string) {
+// Wait blocks until the job gets a slot and returns true, or returns false
+// if ctx ended or the job was evicted first.
+func (q *Queue) Wait(ctx context.Context, id string) bool {
 	atomic.AddInt32(&q.size, 1)
 	defer atomic.AddInt32(&q.size, -1)
 
@@ -49,19 +61,93 @@ func (q *Queue) Wait(ctx context.Context, id string) {
 
 	defer atomic.AddInt32(&q.iter, 1)
 
+	if !q.acquire(ctx) {
+		return false
+	}
+
+	go func() {
+		<-ctx.Done()
+		q.release()
+	}()
+	return true
+}
+
+// Evict wakes every job still waiting for a slot without granting one.
+func (q *Queue) Evict() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for e := q.waiters.Front(); e != nil; e = q.waiters.Front() {
+		q.waiters.Remove(e)
+		close(e.Value.(*queueWaiter).ready)
+	}
+}
+
+// Resize changes the number of slots. Shrinking never preempts running
+// jobs; new jobs simply wait until enough slots have been released.
+func (q *Queue) Resize(capacity int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.capacity = capacity
+	q.grant()
+}
+
+func (q *Queue) Capacity() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.capacity
+}
+
+func (q *Queue) acquire(ctx context.Context) bool {
+	q.mu.Lock()
+	if q.waiters.Len() == 0 && int(q.released) < q.capacity {
+		atomic.AddInt32(&q.released, 1)
+		q.mu.Unlock()
+		return true
+	}
+
+	waiter := &queueWaiter{ready: make(chan struct{})}
+	elem := q.waiters.PushBack(waiter)
+	q.mu.Unlock()
+
 	select {
+	case <-waiter.ready:
+		return waiter.granted
 	case <-ctx.Done():
-		return
-	case q.pool <- struct{}{}:
-		atomic.AddInt32(&q.released, 1)
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		select {
+		case <-waiter.ready:
+			if waiter.granted {
+				// The slot was granted as the context ended, hand it back.
+				atomic.AddInt32(&q.released, -1)
+				q.grant()
+			}
+		default:
+			q.waiters.Remove(elem)
+		}
+		return false
+	}
+}
 
-		go func() {
-			<-ctx.Done()
-			atomic.AddInt32(&q.released, -1)
-			<-q.pool
-		}()
+func (q *Queue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	atomic.AddInt32(&q.released, -1)
+	q.grant()
+}
 
-		return
+// grant hands free slots to waiters in arrival order. Callers hold q.mu.
+func (q *Queue) grant() {
+	for int(atomic.LoadInt32(&q.released)) < q.capacity {
+		front := q.waiters.Front()
+		if front == nil {
+			return
+		}
+		q.waiters.Remove(front)
+		atomic.AddInt32(&q.released, 1)
+		waiter := front.Value.(*queueWaiter)
+		waiter.granted = true
+		close(waiter.ready)
 	}
 }
 
@@ -81,18 +167,23 @@ func (q *Queue) get(id string) *QueueItem {
 
 func (q *Queue) Stats() QueueStats {
 	return QueueStats{
-		Size:     int(q.size),
-		Released: int(q.released),
+		Size:     int(atomic.LoadInt32(&q.size)),
+		Released: int(atomic.LoadInt32(&q.released)),
 	}
 }
 
 func (sf *StarFleet) handleQueue(w http.ResponseWriter, r *http.Request) {
 	id := r.Header.Get("X-Request-ID")
 
+	if ahead, ok := sf.workerPool.Position(id); ok {
+		writeJson(w, id, http.StatusOK, ahead)
+		return
+	}
+
 	var q *Queue
 	var qi *QueueItem
 
-	for _, w := range sf.workerPool.workers {
+	for _, w := range sf.workerPool.Workers() {
 		qi = w.queue.get(id)
 		if qi != nil {
 			q = &w.queue
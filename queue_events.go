@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	queueEventsInterval = 500 * time.Millisecond
+	// queueEventsGrace is how long a client may subscribe before its
+	// generate request has reached the queue.
+	queueEventsGrace = 5 * time.Second
+)
+
+type QueuePositionEvent struct {
+	Ahead         int   `json:"ahead"`
+	EstimatedWait int64 `json:"estimatedWait,omitempty"`
+}
+
+// handleQueueEvents streams the position of a queued job as server-sent
+// events: a "position" event whenever the job moves up, then a "started"
+// event once a worker grants it a slot. Browsers cannot set headers on an
+// EventSource, so the request id may also be passed as ?id=.
+func (sf *StarFleet) handleQueueEvents(w http.ResponseWriter, r *http.Request) {
+	id := r.Header.Get("X-Request-ID")
+	if id == "" {
+		id = r.URL.Query().Get("id")
+	}
+	if id == "" {
+		http.Error(w, "Missing request ID", http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		LogHttpErr(w, id, "Streaming not supported by connection", nil, http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	ticker := time.NewTicker(queueEventsInterval)
+	defer ticker.Stop()
+
+	job := sf.workerPool.Lookup(id)
+	for deadline := time.Now().Add(queueEventsGrace); job == nil; job = sf.workerPool.Lookup(id) {
+		if time.Now().After(deadline) {
+			http.Error(w, "ID is not queued", http.StatusNotFound)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+
+	headers(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	log.Debug().Str("request id", id).Msg("Streaming queue position")
+
+	last := -1
+	for {
+		select {
+		case <-job.Started():
+			writeSSE(w, "started", struct{}{})
+			flusher.Flush()
+			return
+		default:
+		}
+
+		// A job that has been bound to a worker but is not running yet is
+		// next in line.
+		ahead, _ := sf.workerPool.position(func(queued *Job) bool { return queued == job })
+		if ahead != last {
+			last = ahead
+			writeSSE(w, "position", QueuePositionEvent{
+				Ahead:         ahead,
+				EstimatedWait: sf.workerPool.EstimateWait(job, ahead).Milliseconds(),
+			})
+			flusher.Flush()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-job.Started():
+		case <-job.Ctx.Done():
+			select {
+			case <-job.Started():
+			default:
+				writeSSE(w, "ended", struct{}{})
+				flusher.Flush()
+				return
+			}
+		case <-ticker.C:
+		}
+	}
+}
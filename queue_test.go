@@ -2,8 +2,13 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -46,3 +51,148 @@ func simulateQueueJobWithCancel(id string, q *Queue, wg *sync.WaitGroup) {
 	time.Sleep(100 * time.Millisecond)
 	cancel()
 }
+
+func TestQueueResize(t *testing.T) {
+	q := NewQueue(1)
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	defer cancel1()
+	q.Wait(ctx1, "job1")
+
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	granted := make(chan struct{})
+	go func() {
+		q.Wait(ctx2, "job2")
+		close(granted)
+	}()
+
+	select {
+	case <-granted:
+		t.Fatal("job2 should wait for a free slot")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	q.Resize(2)
+
+	select {
+	case <-granted:
+	case <-time.After(time.Second):
+		t.Fatal("job2 should be granted the new slot")
+	}
+
+	if q.Stats().Released != 2 {
+		t.Fatalf("expected 2 released slots, got %d", q.Stats().Released)
+	}
+}
+
+func TestPoolQueue(t *testing.T) {
+	releaseA := make(chan struct{})
+	defer close(releaseA)
+	releaseB := make(chan struct{})
+	simA := simulateBlockingWorker(releaseA)
+	defer simA.Close()
+	simB := simulateBlockingWorker(releaseB)
+	defer simB.Close()
+
+	sf := New(StarFleetConfig{
+		WorkerPoolConfig: WorkerPoolConfig{
+			Workers: []WorkerConfig{
+				{Id: "a", Host: simA.URL, Capacity: 1, FirstTokenTimeout: -1},
+				{Id: "b", Host: simB.URL, Capacity: 1, FirstTokenTimeout: -1},
+			},
+		},
+	})
+	workerPool := sf.workerPool
+	go workerPool.Run()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for i := 0; i < 4; i++ {
+		if err := workerPool.Enlist(NewJob(ctx, fmt.Sprint(i), nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, 5*time.Second, func() bool { return workerPool.Pending() == 2 })
+
+	for id, ahead := range map[string]int{"2": 0, "3": 1} {
+		r := httptest.NewRequest("GET", "/queue", nil)
+		r.Header.Set("X-Request-ID", id)
+		w := httptest.NewRecorder()
+		sf.handleQueue(w, r)
+		if got := strings.TrimSpace(w.Body.String()); got != fmt.Sprint(ahead) {
+			t.Fatalf("expected job %s to have %d ahead, got %s", id, ahead, got)
+		}
+	}
+
+	for _, stats := range workerPool.Stats() {
+		if stats.Requests != 1 {
+			t.Fatalf("expected jobs to be bound only to free workers, got %+v", workerPool.Stats())
+		}
+	}
+
+	// Whichever worker frees up first takes the waiting jobs.
+	close(releaseB)
+	waitFor(t, 5*time.Second, func() bool { return workerPool.Pending() == 0 })
+	if a, b := workerPool.Get("a").Stats().Requests, workerPool.Get("b").Stats().Requests; a != 1 || b != 3 {
+		t.Fatalf("expected the free worker to take the queued jobs, got %d/%d", a, b)
+	}
+}
+
+func TestQueueEvents(t *testing.T) {
+	release := make(chan struct{})
+	sim := simulateBlockingWorker(release)
+	defer sim.Close()
+
+	sf := New(StarFleetConfig{
+		WorkerPoolConfig: WorkerPoolConfig{
+			Workers: []WorkerConfig{{Host: sim.URL, Capacity: 1, FirstTokenTimeout: -1}},
+		},
+	})
+	workerPool := sf.workerPool
+	atomic.StoreInt64(&workerPool.Workers()[0].avgReqTime, 1000)
+	go workerPool.Run()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for i := 0; i < 3; i++ {
+		if err := workerPool.Enlist(NewJob(ctx, fmt.Sprint(i), nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, 5*time.Second, func() bool { return workerPool.Pending() == 2 })
+
+	server := httptest.NewServer(http.HandlerFunc(sf.handleQueueEvents))
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "?id=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %q", ct)
+	}
+	decoder := NewSSEDecoder(res.Body)
+
+	event, err := decoder.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var position QueuePositionEvent
+	if err := json.Unmarshal([]byte(event.Data), &position); err != nil {
+		t.Fatal(err)
+	}
+	if event.Event != "position" || position.Ahead != 1 || position.EstimatedWait != 2000 {
+		t.Fatalf("expected to be second in line with a 2s wait, got %s %+v", event.Event, position)
+	}
+
+	close(release)
+	for event.Event != "started" {
+		if event, err = decoder.Next(); err != nil {
+			t.Fatalf("expected a started event, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const sseMaxLineSize = 1024 * 1024
+
+type SSEEvent struct {
+	Id    string
+	Event string
+	Data  string
+	Retry int
+}
+
+// SSEDecoder incrementally decodes a text/event-stream body. Events may be
+// split across any number of reads, and a single read may hold many events.
+type SSEDecoder struct {
+	scanner *bufio.Scanner
+	lastId  string
+	done    bool
+}
+
+func NewSSEDecoder(r io.Reader) *SSEDecoder {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), sseMaxLineSize)
+	scanner.Split(scanSSELines)
+	return &SSEDecoder{
+		scanner: scanner,
+	}
+}
+
+// Next returns the next dispatched event, or io.EOF once the stream ends.
+// An event left unterminated at the end of the stream is still dispatched.
+func (d *SSEDecoder) Next() (*SSEEvent, error) {
+	if d.done {
+		return nil, io.EOF
+	}
+
+	event := SSEEvent{Id: d.lastId}
+	var data strings.Builder
+	hasData := false
+
+	for d.scanner.Scan() {
+		line := d.scanner.Text()
+
+		if line == "" {
+			if !hasData {
+				event = SSEEvent{Id: d.lastId}
+				continue
+			}
+			event.Data = strings.TrimSuffix(data.String(), "\n")
+			return &event, nil
+		}
+
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+
+		switch field {
+		case "data":
+			data.WriteString(value)
+			data.WriteByte('\n')
+			hasData = true
+		case "event":
+			event.Event = value
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				d.lastId = value
+				event.Id = value
+			}
+		case "retry":
+			if retry, err := strconv.Atoi(value); err == nil {
+				event.Retry = retry
+			}
+		}
+	}
+
+	d.done = true
+
+	if err := d.scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if hasData {
+		event.Data = strings.TrimSuffix(data.String(), "\n")
+		return &event, nil
+	}
+
+	return nil, io.EOF
+}
+
+// scanSSELines splits on CRLF, LF or a lone CR as required by the SSE spec.
+func scanSSELines(data []byte, atEOF bool) (int, []byte, error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		if data[i] == '\n' {
+			return i + 1, data[:i], nil
+		}
+		if i+1 < len(data) {
+			if data[i+1] == '\n' {
+				return i + 2, data[:i], nil
+			}
+			return i + 1, data[:i], nil
+		}
+		if atEOF {
+			return i + 1, data[:i], nil
+		}
+		// A trailing CR may be the first half of a CRLF split across reads.
+		return 0, nil, nil
+	}
+
+	if atEOF {
+		return len(data), data, nil
+	}
+
+	return 0, nil, nil
+}
+
+// writeSSE writes a single event with a JSON encoded payload.
+func writeSSE(w io.Writer, event string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+	return err
+}
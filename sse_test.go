@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+type chunkReader struct {
+	data   string
+	chunks []int
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	size := len(r.data)
+	if len(r.chunks) > 0 {
+		size = r.chunks[0]
+		r.chunks = r.chunks[1:]
+	}
+	if size > len(r.data) {
+		size = len(r.data)
+	}
+	if size > len(p) {
+		size = len(p)
+	}
+	n := copy(p, r.data[:size])
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func collectSSE(t *testing.T, r io.Reader) []SSEEvent {
+	var events []SSEEvent
+	decoder := NewSSEDecoder(r)
+	for {
+		event, err := decoder.Next()
+		if err == io.EOF {
+			return events
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, *event)
+	}
+}
+
+func TestSSEDecoderChunkBoundaries(t *testing.T) {
+	stream := ": keep-alive\r\n" +
+		"id: 1\r\n" +
+		"event: message\r\n" +
+		"data: {\"a\":1}\r\n\r\n" +
+		"data: first\n" +
+		"data: second\n\n" +
+		"id: 2\rdata:no-space\r\r" +
+		"data: [DONE]\n\n"
+
+	expected := []SSEEvent{
+		{Id: "1", Event: "message", Data: "{\"a\":1}"},
+		{Id: "1", Data: "first\nsecond"},
+		{Id: "2", Data: "no-space"},
+		{Id: "2", Data: "[DONE]"},
+	}
+
+	readers := map[string]io.Reader{
+		"whole":      strings.NewReader(stream),
+		"one byte":   iotest.OneByteReader(strings.NewReader(stream)),
+		"split crlf": &chunkReader{data: stream, chunks: []int{13, 1, 7, 30, 2, 1, 5}},
+		"uneven":     &chunkReader{data: stream, chunks: []int{3, 50, 4, 9, 1, 1, 100}},
+	}
+
+	for name, r := range readers {
+		events := collectSSE(t, r)
+		if len(events) != len(expected) {
+			t.Fatalf("%s: expected %d events, got %d: %+v", name, len(expected), len(events), events)
+		}
+		for i := range expected {
+			if events[i] != expected[i] {
+				t.Errorf("%s: event %d: expected %+v, got %+v", name, i, expected[i], events[i])
+			}
+		}
+	}
+}
+
+func TestSSEDecoderUnterminated(t *testing.T) {
+	events := collectSSE(t, strings.NewReader("data: tail"))
+	if len(events) != 1 || events[0].Data != "tail" {
+		t.Fatalf("expected trailing event, got %+v", events)
+	}
+
+	events = collectSSE(t, strings.NewReader("event: ping\n\n: comment\n\n"))
+	if len(events) != 0 {
+		t.Fatalf("expected no events, got %+v", events)
+	}
+}
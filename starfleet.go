@@ -8,20 +8,26 @@ import (
 
 type StarFleetConfig struct {
 	Middleware MiddlewareConfig `json:"middleware"`
-	Workers    []WorkerConfig   `json:"workers"`
+	Admin      *AuthConfig      `json:"admin,omitempty"`
+	WorkerPoolConfig
 }
 type StarFleet struct {
 	middleware     Middleware
 	requestCounter RequestCounterMiddleware
-	workerPool     WorkerPool
+	workerPool     *WorkerPool
+	admin          *Auth
 }
 
 func New(config StarFleetConfig) *StarFleet {
-	return &StarFleet{
+	sf := &StarFleet{
 		middleware:     NewMiddleware(config.Middleware),
 		requestCounter: NewRequestCounterMiddleware(),
-		workerPool:     NewWorkerPool(config.Workers),
+		workerPool:     NewWorkerPool(config.WorkerPoolConfig),
 	}
+	if config.Admin != nil {
+		sf.admin = NewAuth(*config.Admin)
+	}
+	return sf
 }
 
 func (sf *StarFleet) Run() {
@@ -30,10 +36,18 @@ func (sf *StarFleet) Run() {
 	http.HandleFunc("/dashboard", sf.handleDashboard)
 	http.HandleFunc("/dashboard-stats", sf.handleDashboardStats)
 	http.HandleFunc("/dashboard-request-counter", sf.handleDashboardRequestCounter)
+	http.HandleFunc("/dashboard-queue", sf.handleDashboardQueue)
 	http.HandleFunc("/dashboard-revive/", sf.handleDashboardRevive)
 
 	http.HandleFunc("/generate", sf.middleware.Middleware(sf.requestCounter.Middleware(sf.handleGenerate)))
 	http.HandleFunc("/queue", sf.handleQueue)
+	http.HandleFunc("/queue/events", sf.handleQueueEvents)
+
+	if sf.admin != nil {
+		http.HandleFunc("/admin/workers", sf.admin.Middleware(http.HandlerFunc(sf.handleAdminWorkers)))
+		http.HandleFunc("/admin/workers/", sf.admin.Middleware(http.HandlerFunc(sf.handleAdminWorker)))
+		http.HandleFunc("/dashboard-drain/", sf.admin.Middleware(http.HandlerFunc(sf.handleDashboardDrain)))
+	}
 
 	log.Info().Msg("Listening on port :8080")
 	log.Fatal().Err(http.ListenAndServe(":8080", nil)).Msg("fatal error has occurred on port :8080")
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	transportDefaultMaxIdleConns        = 100
+	transportDefaultMaxIdleConnsPerHost = 32
+	transportDefaultIdleConnTimeout     = 90
+	transportDefaultDialTimeout         = 10
+	transportDefaultKeepAlive           = 30
+	transportDefaultTLSHandshakeTimeout = 10
+)
+
+type TransportConfig struct {
+	MaxIdleConns        int    `json:"maxIdleConns,omitempty"`
+	MaxIdleConnsPerHost int    `json:"maxIdleConnsPerHost,omitempty"`
+	MaxConnsPerHost     int    `json:"maxConnsPerHost,omitempty"`
+	IdleConnTimeout     int    `json:"idleConnTimeout,omitempty"`
+	DialTimeout         int    `json:"dialTimeout,omitempty"`
+	KeepAlive           int    `json:"keepAlive,omitempty"`
+	TLSHandshakeTimeout int    `json:"tlsHandshakeTimeout,omitempty"`
+	CAFile              string `json:"caFile,omitempty"`
+	CertFile            string `json:"certFile,omitempty"`
+	KeyFile             string `json:"keyFile,omitempty"`
+	ServerName          string `json:"serverName,omitempty"`
+	InsecureSkipVerify  bool   `json:"insecureSkipVerify,omitempty"`
+	HTTP2               bool   `json:"http2,omitempty"`
+}
+
+func (c *TransportConfig) defaults() {
+	if c.MaxIdleConns <= 0 {
+		c.MaxIdleConns = transportDefaultMaxIdleConns
+	}
+	if c.MaxIdleConnsPerHost <= 0 {
+		c.MaxIdleConnsPerHost = transportDefaultMaxIdleConnsPerHost
+	}
+	if c.IdleConnTimeout <= 0 {
+		c.IdleConnTimeout = transportDefaultIdleConnTimeout
+	}
+	if c.DialTimeout <= 0 {
+		c.DialTimeout = transportDefaultDialTimeout
+	}
+	if c.KeepAlive <= 0 {
+		c.KeepAlive = transportDefaultKeepAlive
+	}
+	if c.TLSHandshakeTimeout <= 0 {
+		c.TLSHandshakeTimeout = transportDefaultTLSHandshakeTimeout
+	}
+}
+
+// NewTransport builds the connection pool a worker uses for every request
+// to its host, including generation, health checks and capacity discovery.
+func NewTransport(config TransportConfig) (*http.Transport, error) {
+	config.defaults()
+
+	tlsConfig, err := newTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   time.Duration(config.DialTimeout) * time.Second,
+		KeepAlive: time.Duration(config.KeepAlive) * time.Second,
+	}
+
+	return &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         dialer.DialContext,
+		MaxIdleConns:        config.MaxIdleConns,
+		MaxIdleConnsPerHost: config.MaxIdleConnsPerHost,
+		MaxConnsPerHost:     config.MaxConnsPerHost,
+		IdleConnTimeout:     time.Duration(config.IdleConnTimeout) * time.Second,
+		TLSHandshakeTimeout: time.Duration(config.TLSHandshakeTimeout) * time.Second,
+		TLSClientConfig:     tlsConfig,
+		ForceAttemptHTTP2:   config.HTTP2,
+	}, nil
+}
+
+func newTLSConfig(config TransportConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         config.ServerName,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+
+	if config.CAFile != "" {
+		pem, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", config.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.CertFile != "" || config.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
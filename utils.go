@@ -30,20 +30,35 @@ func LoadConfig(path string) (*StarFleetConfig, error) {
 }
 
 func IsJsonPath(data any, path []string) bool {
-	for _, key := range path {
-		if m, ok := data.(map[string]any); ok {
-			data = m[key]
-		} else {
-			return false
-		}
-	}
+	data, _ = GetJsonPath(data, path)
 	if b, ok := data.(bool); ok {
 		return b
 	}
 	return false
 }
 
+func GetJsonPath(data any, path []string) (any, bool) {
+	for _, key := range path {
+		m, ok := data.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if data, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return data, true
+}
+
 func LogHttpErr(w http.ResponseWriter, id string, msg string, err error, status int) {
 	log.Error().Err(err).Str("request id", id).Msg(msg)
 	http.Error(w, msg, status)
 }
+
+func writeJson(w http.ResponseWriter, id string, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error().Err(err).Str("request id", id).Msg("Failed to write response")
+	}
+}
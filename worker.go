@@ -1,14 +1,12 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
-	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -17,22 +15,56 @@ import (
 )
 
 const (
-	workerDefaultHeartbeat  = 1
-	workerDefaultTimeout    = 20
-	workerDefaultMaxRetries = 10
+	workerDefaultHeartbeat      = 1
+	workerDefaultTimeout        = 20
+	workerDefaultStartupTimeout = 120
+	workerDefaultFirstToken     = 120
+	workerDefaultTokenTimeout   = 60
+	workerDefaultWeight         = 1
+
+	workerFirstTokenAlpha = 0.3
+
+	workerMinRestartBackoff = 1 * time.Second
+	workerMaxRestartBackoff = 60 * time.Second
 )
 
+var errWorkerDraining = errors.New("LLM is draining")
+
 type WorkerConfig struct {
-	Host             string            `json:"host"`
-	Capacity         int               `json:"capacity"`
-	Heartbeat        int               `json:"heartbeat,omitempty"`
-	Timeout          int               `json:"timeout,omitempty"`
-	CheckAlive       bool              `json:"checkAlive,omitempty"`
-	MaxRetries       int               `json:"maxRetries,omitempty"`
-	Restart          bool              `json:"restart,omitempty"`
-	Headers          map[string]string `json:"headers,omitempty"`
-	GenerateEndpoint string            `json:"generateEndpoint,omitempty"`
-	OpenAI           bool              `json:"openai,omitempty"`
+	Id                string            `json:"id,omitempty"`
+	Host              string            `json:"host"`
+	Capacity          int               `json:"capacity"`
+	Heartbeat         int               `json:"heartbeat,omitempty"`
+	Timeout           int               `json:"timeout,omitempty"`
+	FirstTokenTimeout int               `json:"firstTokenTimeout,omitempty"`
+	TokenTimeout      int               `json:"tokenTimeout,omitempty"`
+	GenerationTimeout int               `json:"generationTimeout,omitempty"`
+	CheckAlive        bool              `json:"checkAlive,omitempty"`
+	Restart           bool              `json:"restart,omitempty"`
+	Headers           map[string]string `json:"headers,omitempty"`
+	GenerateEndpoint  string            `json:"generateEndpoint,omitempty"`
+	OpenAI            bool              `json:"openai,omitempty"`
+	Backend           string            `json:"backend,omitempty"`
+	Command           string            `json:"command,omitempty"`
+	Args              []string          `json:"args,omitempty"`
+	Env               map[string]string `json:"env,omitempty"`
+	Workdir           string            `json:"workdir,omitempty"`
+	StartupTimeout    int               `json:"startupTimeout,omitempty"`
+	Breaker           BreakerConfig     `json:"breaker,omitempty"`
+	Health            HealthConfig      `json:"health,omitempty"`
+	Transport         TransportConfig   `json:"transport,omitempty"`
+	Models            []string          `json:"models,omitempty"`
+	Weight            int               `json:"weight,omitempty"`
+	Group             string            `json:"group,omitempty"`
+	Labels            map[string]string `json:"labels,omitempty"`
+	Dedicated         bool              `json:"dedicated,omitempty"`
+	ContextWindow     int               `json:"contextWindow,omitempty"`
+	MaxQueueDepth     int               `json:"maxQueueDepth,omitempty"`
+
+	// Deprecated: a worker is taken out by its circuit breaker, and failed
+	// jobs are retried up to the pool's failover attempts. Kept so existing
+	// configs still load; setting it only logs a warning.
+	MaxRetries int `json:"maxRetries,omitempty"`
 }
 
 func (c *WorkerConfig) defaults() {
@@ -42,48 +74,128 @@ func (c *WorkerConfig) defaults() {
 	if c.Timeout <= 0 {
 		c.Timeout = workerDefaultTimeout
 	}
-	if c.GenerateEndpoint == "" {
-		c.GenerateEndpoint = "/generate"
+	if c.Weight <= 0 {
+		c.Weight = workerDefaultWeight
+	}
+	if c.FirstTokenTimeout == 0 {
+		c.FirstTokenTimeout = workerDefaultFirstToken
+	}
+	if c.TokenTimeout == 0 {
+		c.TokenTimeout = workerDefaultTokenTimeout
+	}
+	if c.StartupTimeout <= 0 {
+		c.StartupTimeout = workerDefaultStartupTimeout
 	}
 	if c.Headers == nil {
 		c.Headers = make(map[string]string)
 	}
+	if c.Backend == "" {
+		if c.OpenAI {
+			c.Backend = backendOpenAI
+		} else {
+			c.Backend = backendRaw
+		}
+	}
+	if c.Capacity <= 0 && c.Backend == backendLlamaCpp {
+		// Placeholder until the real slot count is discovered on heartbeat.
+		c.Capacity = 1
+	}
+	if c.GenerateEndpoint == "" {
+		switch c.Backend {
+		case backendOllama:
+			c.GenerateEndpoint = "/api/generate"
+		case backendTGI:
+			c.GenerateEndpoint = "/generate_stream"
+		case backendLlamaCpp:
+			c.GenerateEndpoint = "/completion"
+		default:
+			c.GenerateEndpoint = "/generate"
+		}
+	}
 }
 
 type WorkerStats struct {
-	Host           string
-	Alive          bool
-	Capacity       int
-	Queued         int
-	Released       int
-	Running        int
-	Requests       int
-	Finished       int
-	Successes      int
-	Fails          int
-	AvgRequestTime int
+	Id                 string
+	Group              string
+	Host               string
+	Alive              bool
+	Draining           bool
+	Drained            bool
+	Breaker            string
+	Models             []string
+	Labels             map[string]string
+	Dedicated          bool
+	ContextWindow      int
+	Weight             int
+	FirstTokenTime     int
+	Capacity           int
+	Queued             int
+	Released           int
+	Running            int
+	Requests           int
+	Finished           int
+	Successes          int
+	Fails              int
+	HedgesIssued       int
+	HedgesWon          int
+	FirstTokenTimeouts int
+	TokenTimeouts      int
+	GenerationTimeouts int
+	AvgRequestTime     int
+	PromptTokens       int
+	CompletionTokens   int
+	LoadTime           int
+	PromptEvalTime     int
+	EvalTime           int
 }
 
 type Worker struct {
-	Alive bool
-	Jobs  chan *Job
-	host  string
-	queue Queue
-
-	capacity  int
-	running   int32
-	requests  int32
-	finished  int32
-	fails     int32
-	successes int32
-	failCount int32
-
-	maxRetries int
+	Alive    bool
+	Jobs     chan *Job
+	id       string
+	group    string
+	done     chan struct{}
+	stopOnce sync.Once
+	host     string
+	queue    Queue
+
+	draining bool
+	idle     chan struct{}
+	drainMu  sync.Mutex
+
+	running     int32
+	outstanding int32
+	requests    int32
+	finished    int32
+	fails       int32
+
+	hedgesIssued int32
+	hedgesWon    int32
+
+	firstTokenTimeouts int32
+	tokenTimeouts      int32
+	generationTimeouts int32
+	successes          int32
+
+	breaker    *Breaker
 	restart    bool
+	restarting int32
+	process    *Process
+
+	startupTimeout time.Duration
 
 	avgReqTime   int64
 	totalReqTime int64
 
+	firstTokenEWMA float64
+	firstTokenMu   sync.Mutex
+
+	promptTokens     int64
+	completionTokens int64
+	loadTime         int64
+	promptEvalTime   int64
+	evalTime         int64
+
 	heartbeat      time.Duration
 	isHeartbeating bool
 	hbMu           sync.Mutex
@@ -91,87 +203,428 @@ type Worker struct {
 	timeout    time.Duration
 	checkAlive bool
 
+	firstTokenTimeout time.Duration
+	tokenTimeout      time.Duration
+	generationTimeout time.Duration
+
+	health *HealthCheck
+
+	client           *http.Client
 	headers          map[string]string
+	headersMu        sync.RWMutex
+	models           map[string]bool
+	weight           int
+	contextWindow    int
 	generateEndpoint string
-	openai           bool
+	backend          Backend
 
 	config WorkerConfig
+
+	onRelease func()
 }
 
 func NewWorker(config WorkerConfig) *Worker {
+	w, err := newWorker(config)
+	if err != nil {
+		panic(err)
+	}
+	return w
+}
+
+func newWorker(config WorkerConfig) (*Worker, error) {
+	if config.Host == "" {
+		return nil, fmt.Errorf("worker host is required")
+	}
+
 	config.defaults()
-	return &Worker{
-		Alive:            true,
-		Jobs:             make(chan *Job, config.Capacity*2),
-		host:             config.Host,
-		queue:            NewQueue(config.Capacity),
-		capacity:         config.Capacity,
-		running:          0,
-		requests:         0,
-		finished:         0,
-		fails:            0,
-		successes:        0,
-		failCount:        0,
-		restart:          config.Restart,
-		maxRetries:       config.MaxRetries,
-		avgReqTime:       0,
-		totalReqTime:     0,
-		heartbeat:        time.Duration(config.Heartbeat) * time.Second,
-		isHeartbeating:   false,
-		hbMu:             sync.Mutex{},
-		timeout:          time.Duration(config.Timeout) * time.Second,
-		checkAlive:       config.CheckAlive,
-		headers:          config.Headers,
-		generateEndpoint: config.GenerateEndpoint,
-		openai:           config.OpenAI,
-		config:           config,
+
+	if config.Capacity <= 0 {
+		return nil, fmt.Errorf("worker capacity must be positive")
+	}
+
+	if config.MaxRetries != 0 {
+		log.Warn().Str("host", config.Host).Msg("Worker maxRetries is deprecated and ignored, use breaker and failover instead")
+	}
+
+	backend, err := NewBackend(config)
+	if err != nil {
+		return nil, err
 	}
+
+	transport, err := NewTransport(config.Transport)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Worker{
+		Alive:             config.Command == "",
+		Jobs:              make(chan *Job, config.Capacity*2),
+		id:                config.Id,
+		group:             config.Group,
+		done:              make(chan struct{}),
+		idle:              make(chan struct{}),
+		host:              config.Host,
+		queue:             NewQueue(config.Capacity),
+		running:           0,
+		requests:          0,
+		finished:          0,
+		fails:             0,
+		successes:         0,
+		restart:           config.Restart,
+		restarting:        0,
+		startupTimeout:    time.Duration(config.StartupTimeout) * time.Second,
+		breaker:           NewBreaker(config.Breaker),
+		avgReqTime:        0,
+		totalReqTime:      0,
+		heartbeat:         time.Duration(config.Heartbeat) * time.Second,
+		isHeartbeating:    false,
+		hbMu:              sync.Mutex{},
+		timeout:           time.Duration(config.Timeout) * time.Second,
+		firstTokenTimeout: time.Duration(config.FirstTokenTimeout) * time.Second,
+		tokenTimeout:      time.Duration(config.TokenTimeout) * time.Second,
+		generationTimeout: time.Duration(config.GenerationTimeout) * time.Second,
+		checkAlive:        config.CheckAlive,
+		health:            NewHealthCheck(config.Health),
+		client:            &http.Client{Transport: transport},
+		headers:           config.Headers,
+		models:            make(map[string]bool, len(config.Models)),
+		weight:            config.Weight,
+		contextWindow:     config.ContextWindow,
+		generateEndpoint:  config.GenerateEndpoint,
+		backend:           backend,
+		config:            config,
+	}
+
+	for _, model := range config.Models {
+		w.models[model] = true
+	}
+
+	w.breaker.onChange = w.onBreakerChange
+
+	if config.Command != "" {
+		w.process = NewProcess(config)
+		w.process.onExit = func(error) {
+			if w.restart {
+				go w.doRestart()
+			}
+		}
+	}
+
+	return w, nil
 }
 
 func (w *Worker) Work() {
-	if w.checkAlive {
-		go w.doHearbeat()
+	// Capacity is discovered once at startup, and again on every heartbeat
+	// when liveness is checked.
+	if w.process != nil {
+		go w.doRestart()
+	} else {
+		go w.discoverCapacity()
+		if w.config.CheckAlive {
+			go w.doHearbeat()
+		}
 	}
 
-	for job := range w.Jobs {
-		if !w.Alive {
-			job.Err <- fmt.Errorf("LLM became unresponsive")
-			job.Finish()
-			continue
+	for {
+		select {
+		case job := <-w.Jobs:
+			if !w.IsAlive() {
+				go w.reject(job, fmt.Errorf("LLM became unresponsive"))
+				continue
+			}
+			go w.generate(job)
+		case <-w.done:
+			for {
+				select {
+				case job := <-w.Jobs:
+					go w.reject(job, fmt.Errorf("LLM was removed"))
+				default:
+					return
+				}
+			}
 		}
-		go w.generate(job)
 	}
 }
 
+// reject hands a job the worker will not run to another worker, or fails it.
+func (w *Worker) reject(job *Job, err error) {
+	w.untrack()
+	if !job.detach(w) {
+		return
+	}
+	if !job.Failover(w, err) {
+		job.Err <- err
+		job.Finish()
+	}
+}
+
+// Stop takes the worker out of service. Running jobs are left to finish,
+// jobs that have not started are moved to other workers.
+func (w *Worker) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.done)
+
+		w.hbMu.Lock()
+		w.checkAlive = false
+		w.hbMu.Unlock()
+
+		if w.process != nil {
+			go w.process.Stop()
+		}
+	})
+}
+
+func (w *Worker) stopped() bool {
+	select {
+	case <-w.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (w *Worker) SetHeaders(headers map[string]string) {
+	w.headersMu.Lock()
+	defer w.headersMu.Unlock()
+	w.headers = headers
+}
+
+func (w *Worker) getHeaders() map[string]string {
+	w.headersMu.RLock()
+	defer w.headersMu.RUnlock()
+	return w.headers
+}
+
+// Submit hands a job to the worker, giving up if the request ends first.
+func (w *Worker) Submit(job *Job) bool {
+	atomic.AddInt32(&w.outstanding, 1)
+	job.enter(w)
+	select {
+	case <-job.ReqCtx.Done():
+		job.leave()
+		w.untrack()
+		return false
+	case <-w.done:
+		job.leave()
+		w.untrack()
+		return false
+	case w.Jobs <- job:
+		return true
+	}
+}
+
+// Revive puts the worker back in service at an operator's request, ending
+// any drain.
 func (w *Worker) Revive() {
+	w.revive()
+	w.undrain()
+}
+
+// revive marks the worker alive again. A worker that is being drained stays
+// out of rotation.
+func (w *Worker) revive() {
 	if w.config.CheckAlive {
 		w.hbMu.Lock()
 		defer w.hbMu.Unlock()
 		w.checkAlive = true
 		w.Alive = true
-		go w.doHearbeat()
+		if !w.isHeartbeating {
+			go w.doHearbeat()
+		}
 	} else {
-		w.Alive = true
+		w.setAlive(true)
 	}
+	w.breaker.Reset()
+}
+
+// Drain stops the worker from being assigned new jobs. Running jobs finish
+// here, jobs that have not started are moved to other workers where
+// possible. The returned channel is closed once the worker is idle.
+func (w *Worker) Drain() <-chan struct{} {
+	w.drainMu.Lock()
+	defer w.drainMu.Unlock()
+
+	if !w.draining {
+		w.draining = true
+		log.Info().Str("worker host", w.host).Int("outstanding", w.Outstanding()).Msg("Draining worker")
+		w.queue.Evict()
+		w.checkIdle()
+	}
+	return w.idle
+}
+
+func (w *Worker) Draining() bool {
+	w.drainMu.Lock()
+	defer w.drainMu.Unlock()
+	return w.draining
+}
+
+// Drained reports whether the worker is draining and has no jobs left.
+func (w *Worker) Drained() bool {
+	w.drainMu.Lock()
+	defer w.drainMu.Unlock()
+	select {
+	case <-w.idle:
+		return true
+	default:
+		return false
+	}
+}
+
+func (w *Worker) undrain() {
+	w.drainMu.Lock()
+	defer w.drainMu.Unlock()
+	if w.draining {
+		w.draining = false
+		w.idle = make(chan struct{})
+		log.Info().Str("worker host", w.host).Msg("Worker is back in service")
+	}
+}
+
+// checkIdle reports a draining worker that has run out of jobs. Callers hold
+// w.drainMu.
+func (w *Worker) checkIdle() {
+	if !w.draining || w.Outstanding() > 0 {
+		return
+	}
+	select {
+	case <-w.idle:
+	default:
+		close(w.idle)
+		log.Info().Str("worker host", w.host).Msg("Worker has drained and is idle")
+	}
+}
+
+// untrack marks an outstanding job as done.
+func (w *Worker) untrack() {
+	if atomic.AddInt32(&w.outstanding, -1) == 0 {
+		w.drainMu.Lock()
+		w.checkIdle()
+		w.drainMu.Unlock()
+	}
+	if w.onRelease != nil {
+		w.onRelease()
+	}
+}
+
+// handOff moves a job that has not started off a draining worker. It returns
+// false if no other worker can take it, in which case it runs here.
+func (w *Worker) handOff(job *Job) bool {
+	if job.onDrain == nil || !job.onDrain(job, w) {
+		return false
+	}
+	job.detach(w)
+	w.untrack()
+	return true
+}
+
+// Serves reports whether the worker serves model. Workers that declare no
+// models serve any model.
+func (w *Worker) Serves(model string) bool {
+	return model == "" || len(w.models) == 0 || w.models[model]
+}
+
+// Matches reports whether the worker carries every label in selector.
+// Dedicated workers only take jobs that select them.
+func (w *Worker) Matches(selector map[string]string) bool {
+	if w.config.Dedicated && len(selector) == 0 {
+		return false
+	}
+	for label, value := range selector {
+		if w.config.Labels[label] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Available reports whether the worker may be assigned new jobs.
+func (w *Worker) Available() bool {
+	return w.IsAlive() && !w.stopped() && !w.Draining() && w.breaker.Ready()
+}
+
+// IsAlive reports whether the worker's last health check passed. Alive is
+// written by the heartbeat and restart goroutines under hbMu.
+func (w *Worker) IsAlive() bool {
+	w.hbMu.Lock()
+	defer w.hbMu.Unlock()
+	return w.Alive
+}
+
+func (w *Worker) setAlive(alive bool) {
+	w.hbMu.Lock()
+	defer w.hbMu.Unlock()
+	w.Alive = alive
 }
 
 func (w *Worker) Load() float64 {
-	return float64(len(w.Jobs)+w.queue.Stats().Size+int(w.running)) / float64(w.capacity)
+	return float64(w.Outstanding()) / float64(w.queue.Capacity())
+}
+
+// Outstanding is the number of jobs assigned to the worker that have not
+// finished, whether waiting or running.
+func (w *Worker) Outstanding() int {
+	return int(atomic.LoadInt32(&w.outstanding))
+}
+
+// hasSlot reports whether the worker can take another job without queuing
+// it.
+func (w *Worker) hasSlot() bool {
+	return w.Outstanding() < w.queue.Capacity()
+}
+
+// FirstTokenEWMA is the moving average time to first token in milliseconds.
+func (w *Worker) FirstTokenEWMA() float64 {
+	w.firstTokenMu.Lock()
+	defer w.firstTokenMu.Unlock()
+	return w.firstTokenEWMA
+}
+
+func (w *Worker) observeFirstToken(d time.Duration) {
+	w.firstTokenMu.Lock()
+	defer w.firstTokenMu.Unlock()
+	ms := float64(d) / float64(time.Millisecond)
+	if w.firstTokenEWMA == 0 {
+		w.firstTokenEWMA = ms
+	} else {
+		w.firstTokenEWMA = workerFirstTokenAlpha*ms + (1-workerFirstTokenAlpha)*w.firstTokenEWMA
+	}
 }
 
 func (w *Worker) Stats() WorkerStats {
 	return WorkerStats{
-		Host:           w.host,
-		Capacity:       w.capacity,
-		Alive:          w.Alive,
-		Queued:         w.queue.Stats().Size,
-		Released:       w.queue.Stats().Released,
-		Running:        int(w.running),
-		Requests:       int(w.requests),
-		Finished:       int(w.finished),
-		Successes:      int(w.successes),
-		Fails:          int(w.fails),
-		AvgRequestTime: int(w.avgReqTime),
+		Id:                 w.id,
+		Group:              w.group,
+		Host:               w.host,
+		Capacity:           w.queue.Capacity(),
+		Alive:              w.IsAlive(),
+		Draining:           w.Draining(),
+		Drained:            w.Drained(),
+		Breaker:            w.breaker.State().String(),
+		Models:             w.config.Models,
+		Labels:             w.config.Labels,
+		Dedicated:          w.config.Dedicated,
+		ContextWindow:      w.contextWindow,
+		Weight:             w.weight,
+		FirstTokenTime:     int(w.FirstTokenEWMA()),
+		Queued:             w.queue.Stats().Size,
+		Released:           w.queue.Stats().Released,
+		Running:            int(atomic.LoadInt32(&w.running)),
+		Requests:           int(atomic.LoadInt32(&w.requests)),
+		Finished:           int(atomic.LoadInt32(&w.finished)),
+		Successes:          int(atomic.LoadInt32(&w.successes)),
+		Fails:              int(atomic.LoadInt32(&w.fails)),
+		HedgesIssued:       int(atomic.LoadInt32(&w.hedgesIssued)),
+		HedgesWon:          int(atomic.LoadInt32(&w.hedgesWon)),
+		FirstTokenTimeouts: int(atomic.LoadInt32(&w.firstTokenTimeouts)),
+		TokenTimeouts:      int(atomic.LoadInt32(&w.tokenTimeouts)),
+		GenerationTimeouts: int(atomic.LoadInt32(&w.generationTimeouts)),
+		AvgRequestTime:     int(atomic.LoadInt64(&w.avgReqTime)),
+		PromptTokens:       int(atomic.LoadInt64(&w.promptTokens)),
+		CompletionTokens:   int(atomic.LoadInt64(&w.completionTokens)),
+		LoadTime:           int(atomic.LoadInt64(&w.loadTime)),
+		PromptEvalTime:     int(atomic.LoadInt64(&w.promptEvalTime)),
+		EvalTime:           int(atomic.LoadInt64(&w.evalTime)),
 	}
 }
 
@@ -187,13 +640,21 @@ func (w *Worker) doHearbeat() {
 	}()
 
 	for range time.Tick(w.heartbeat) {
+		if !w.heartbeating() {
+			return
+		}
+
+		// The probe may take as long as the health timeout, so it runs
+		// without hbMu to keep IsAlive cheap.
+		ok := w.ping()
+
 		w.hbMu.Lock()
 		if !w.checkAlive {
 			w.hbMu.Unlock()
 			return
 		}
 
-		alive := w.ping()
+		alive := w.health.Observe(w.Alive, ok)
 		if w.Alive && !alive {
 			log.Error().Str("host", w.host).Msg("Worker has died")
 		}
@@ -203,62 +664,165 @@ func (w *Worker) doHearbeat() {
 
 		w.Alive = alive
 		w.hbMu.Unlock()
+
+		if alive {
+			w.discoverCapacity()
+		}
 	}
 }
 
-func (w *Worker) ping() bool {
-	client := http.Client{Timeout: 5 * time.Second}
-	resp, err := client.Get(w.host)
+func (w *Worker) heartbeating() bool {
+	w.hbMu.Lock()
+	defer w.hbMu.Unlock()
+	return w.checkAlive
+}
+
+func (w *Worker) discoverCapacity() {
+	backend, ok := w.backend.(CapacityBackend)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	capacity, err := backend.Capacity(ctx, func(path string) (*http.Response, error) {
+		return w.get(ctx, path)
+	})
+	if err != nil {
+		log.Warn().Err(err).Str("host", w.host).Msg("Failed to discover worker capacity")
+		return
+	}
+
+	w.Resize(capacity)
+}
+
+func (w *Worker) Resize(capacity int) {
+	current := w.queue.Capacity()
+	if capacity <= 0 || capacity == current {
+		return
+	}
+	log.Info().Str("host", w.host).Int("from", current).Int("to", capacity).Msg("Resizing worker capacity")
+	w.queue.Resize(capacity)
+}
+
+func (w *Worker) get(ctx context.Context, path string) (*http.Response, error) {
+	path, err := url.JoinPath(w.host, path)
 	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for h, v := range w.getHeaders() {
+		req.Header.Set(h, v)
+	}
+
+	return w.client.Do(req)
+}
+
+func (w *Worker) ping() bool {
+	if err := w.health.Check(w.client, w.host, w.getHeaders()); err != nil {
+		log.Debug().Err(err).Str("host", w.host).Msg("Worker health check failed")
 		return false
 	}
-	defer resp.Body.Close()
-	return resp.StatusCode == http.StatusOK
+	return true
 }
 
 func (w *Worker) generate(job *Job) {
+	ctx, cancel := context.WithCancel(job.Ctx)
+	defer cancel()
+
+	if !job.attach(w, cancel) {
+		// Another worker won the job while this one was still queued.
+		job.detach(w)
+		w.untrack()
+		return
+	}
+
+	if w.Draining() && w.handOff(job) {
+		return
+	}
+
 	atomic.AddInt32(&w.requests, 1)
-	w.queue.Wait(job.Ctx, job.Id)
+	for !w.queue.Wait(ctx, job.Id) && ctx.Err() == nil {
+		// Evicted by Drain before getting a slot.
+		if w.handOff(job) {
+			return
+		}
+	}
+	if ctx.Err() == nil {
+		job.begin()
+	}
 	atomic.AddInt32(&w.running, 1)
 
 	failed := false
 	early := false
+	allowed := false
+	var generation uint64
+	emitted := false
+	failedOver := false
+
+	// detach ends this attempt and reports whether its outcome decides the
+	// job, which is not the case while a hedged attempt is still running.
+	detached, last := false, false
+	detach := func() bool {
+		if !detached {
+			detached, last = true, job.detach(w)
+		}
+		return last
+	}
 
 	reqTime := time.Now().UnixMilli()
 
 	defer func() {
 		log.Info().Str("request id", job.Id).Str("worker host", w.host).Msg("Finishing generate request with worker")
 
-		job.Finish()
+		if detach() && !failedOver {
+			job.Finish()
+		}
+
+		w.untrack()
 
 		atomic.AddInt32(&w.running, -1)
 		atomic.AddInt32(&w.finished, 1)
 
 		if failed {
-			w.countFail()
+			w.countFail(generation)
 		} else if !early {
-			w.countSuccess()
+			w.countSuccess(generation)
+		} else if allowed {
+			w.breaker.Cancel(generation)
 		}
 
-		if atomic.LoadInt32(&w.failCount) >= int32(w.maxRetries) {
-			log.Warn().Str("worker host", w.host).Msgf("Worker has failed after %v retries", atomic.LoadInt32(&w.failCount))
-
-			w.hbMu.Lock()
-			w.checkAlive = false
-			w.Alive = false
-			w.hbMu.Unlock()
+		w.calcAvgReqTime(reqTime)
+	}()
 
-			if w.restart {
-				go w.doRestart()
-			}
+	// fail counts err against the worker and reports it to the client, unless
+	// another attempt at the job is still running, or no tokens have been sent
+	// yet and the job could be moved to another worker instead. Errors caused
+	// by the client going away say nothing about the worker and are neither
+	// counted nor retried.
+	fail := func(err error, clientErr error) {
+		if job.ReqCtx.Err() != nil || job.lost(w) {
+			// Losing a hedge race is not a failure either.
+			early = true
+			detach()
+			return
 		}
-
-		if !w.Alive {
-			atomic.StoreInt32(&w.failCount, 0)
+		failed = true
+		if !detach() {
+			return
 		}
-
-		w.calcAvgReqTime(reqTime)
-	}()
+		if !emitted && job.Failover(w, err) {
+			failedOver = true
+			return
+		}
+		job.Err <- clientErr
+	}
 
 	select {
 	case <-job.ReqCtx.Done():
@@ -267,49 +831,102 @@ func (w *Worker) generate(job *Job) {
 	default:
 	}
 
-	res, err := w.prompt(job.Ctx, job.Payload)
-	if err != nil {
+	if job.lost(w) {
+		early = true
+		return
+	}
+
+	if generation, allowed = w.breaker.Allow(); !allowed {
+		early = true
+		err := fmt.Errorf("LLM circuit breaker is %v", w.breaker.State())
+		if !detach() {
+			return
+		}
+		if job.Failover(w, err) {
+			failedOver = true
+			return
+		}
+		job.Err <- err
+		return
+	}
+
+	genCtx, deadline := newWatchdog(ctx, w.generationTimeout)
+	defer deadline.stop()
+	deadline.arm(w.firstTokenTimeout, errFirstTokenTimeout)
+
+	// timedOut reports an expired deadline in place of the error it caused.
+	timedOut := func() bool {
+		err := deadline.Err()
+		if err == nil {
+			return false
+		}
+		log.Error().Err(err).Str("request id", job.Id).Str("worker host", w.host).Msg("LLM deadline expired")
+		w.countTimeout(err)
+		fail(err, err)
+		return true
+	}
+
+	promptTime := time.Now()
+	res, err := w.prompt(genCtx, job.Payload)
+	if timedOut() {
+		return
+	} else if serr, ok := err.(*statusError); ok && serr.status < 500 {
+		log.Error().Err(err).Str("request id", job.Id).Str("worker host", w.host).Msg("LLM rejected the request")
+		if detach() {
+			job.Err <- err
+		}
+		early = true
+		return
+	} else if err != nil {
 		log.Error().Err(err).Str("request id", job.Id).Str("worker host", w.host).Msg("Error prompting LLM")
 		//lint:ignore ST1005 frontend error
-		job.Err <- fmt.Errorf("Error prompting LLM")
-		failed = true
+		fail(err, fmt.Errorf("Error prompting LLM"))
 		return
 	}
 	defer res.Body.Close()
 
 	log.Info().Str("request id", job.Id).Str("worker host", w.host).Msg("Initiated generate request with worker")
 
+	stream := w.backend.Decode(res.Body)
+
 	for {
-		data := make([]byte, 1024)
-		size, err := res.Body.Read(data)
-		if err == io.EOF {
+		token, err := stream.Next()
+		deadline.disarm()
+		if timedOut() {
+			return
+		} else if err == io.EOF {
+			w.countUsage(stream.Usage())
+			return
+		} else if berr, ok := err.(*BackendError); ok {
+			log.Error().Err(berr).Str("request id", job.Id).Str("worker host", w.host).Msg("LLM reported an error")
+			//lint:ignore ST1005 frontend error
+			fail(err, fmt.Errorf("LLM error: %s", berr.Message))
 			return
 		} else if err != nil {
 			log.Error().Err(err).Str("request id", job.Id).Str("worker host", w.host).Msg("Error reading tokens from LLM")
 			//lint:ignore ST1005 frontend error
-			job.Err <- fmt.Errorf("Error reading tokens from LLM")
-			failed = true
+			fail(err, fmt.Errorf("Error reading tokens from LLM"))
 			return
 		}
 
-		token := string(data[:size])
-		if w.openai {
-			if token, err = w.openaiFilter(token); err == io.EOF {
-				return
-			} else if err != nil {
-				log.Error().Err(err).Str("request id", job.Id).Str("worker host", w.host).Msg("Error reading tokens from LLM")
-				//lint:ignore ST1005 frontend error
-				job.Err <- fmt.Errorf("Error reading tokens from LLM")
-				failed = true
+		if !emitted {
+			if !job.claim(w) {
+				early = true
 				return
 			}
+			if job.isHedge(w) {
+				atomic.AddInt32(&w.hedgesWon, 1)
+			}
+			w.observeFirstToken(time.Since(promptTime))
 		}
 
 		select {
 		case job.Output <- token:
+			emitted = true
 			if token == "" {
 				return
 			}
+			deadline.arm(w.tokenTimeout, errTokenTimeout)
 		case <-job.ReqCtx.Done():
 			early = true
 			return
@@ -327,70 +944,138 @@ func (w *Worker) prompt(ctx context.Context, payload []byte) (*http.Response, er
 		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", path, bytes.NewBuffer(payload))
+	req, err := w.backend.Request(ctx, path, payload)
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Set("Cache-Control", "no-cache")
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Connection", "keep-alive")
-
-	for h, v := range w.headers {
+	for h, v := range w.getHeaders() {
 		req.Header.Set(h, v)
 	}
 
-	client := http.Client{}
-	return client.Do(req.WithContext(ctx))
+	res, err := w.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		res.Body.Close()
+		return nil, &statusError{status: res.StatusCode}
+	}
+
+	return res, nil
 }
 
+// doRestart (re)launches the worker's process with exponential backoff
+// until it passes a health check, then marks the worker alive again.
 func (w *Worker) doRestart() {
+	if w.process == nil {
+		log.Warn().Str("host", w.host).Msg("Worker has no command to restart")
+		return
+	}
 
+	if !atomic.CompareAndSwapInt32(&w.restarting, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&w.restarting, 0)
+
+	w.hbMu.Lock()
+	w.checkAlive = false
+	w.Alive = false
+	w.hbMu.Unlock()
+
+	backoff := workerMinRestartBackoff
+	for attempt := 1; !w.stopped(); attempt++ {
+		log.Warn().Str("host", w.host).Int("attempt", attempt).Msg("Launching worker process")
+
+		w.process.Stop()
+		err := w.process.Start()
+
+		// A Remove that landed while the process was starting found nothing
+		// to stop, so the process it missed is stopped here.
+		if w.stopped() {
+			w.process.Stop()
+			return
+		}
+
+		if err != nil {
+			log.Error().Err(err).Str("host", w.host).Msg("Failed to launch worker process")
+		} else if w.waitHealthy() {
+			log.Warn().Str("host", w.host).Msg("Worker process is healthy")
+			w.discoverCapacity()
+			w.revive()
+			return
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > workerMaxRestartBackoff {
+			backoff = workerMaxRestartBackoff
+		}
+	}
+}
+
+func (w *Worker) waitHealthy() bool {
+	deadline := time.Now().Add(w.startupTimeout)
+	for time.Now().Before(deadline) {
+		if !w.process.Running() || w.stopped() {
+			return false
+		}
+		if w.ping() {
+			return true
+		}
+		time.Sleep(w.heartbeat)
+	}
+	log.Error().Str("host", w.host).Msgf("Worker process was not healthy after %v", w.startupTimeout)
+	return false
 }
 
-func (w *Worker) countSuccess() {
+func (w *Worker) countSuccess(generation uint64) {
 	atomic.AddInt32(&w.successes, 1)
-	atomic.StoreInt32(&w.failCount, 0)
+	w.breaker.Record(generation, true)
 }
 
-func (w *Worker) countFail() {
+func (w *Worker) countFail(generation uint64) {
 	atomic.AddInt32(&w.fails, 1)
-	atomic.AddInt32(&w.failCount, 1)
+	w.breaker.Record(generation, false)
 }
 
-func (w *Worker) calcAvgReqTime(reqTime int64) {
-	reqTime = time.Now().UnixMilli() - reqTime
-	atomic.AddInt64(&w.totalReqTime, reqTime)
+func (w *Worker) onBreakerChange(from, to BreakerState) {
+	log.Warn().Str("worker host", w.host).Str("from", from.String()).Str("to", to.String()).Msg("Worker circuit breaker changed state")
 
-	totalReqTime := atomic.LoadInt64(&w.totalReqTime)
-	numRequests := atomic.LoadInt32(&w.finished)
-
-	w.avgReqTime = totalReqTime / int64(numRequests)
+	if to == BreakerOpen && from == BreakerClosed && w.restart {
+		go w.doRestart()
+	}
 }
 
-type openaiResponse struct {
-	Choices []struct {
-		Delta struct {
-			Content string `json:"content"`
-		} `json:"delta"`
-	} `json:"choices"`
+func (w *Worker) countTimeout(err error) {
+	switch {
+	case errors.Is(err, errFirstTokenTimeout):
+		atomic.AddInt32(&w.firstTokenTimeouts, 1)
+	case errors.Is(err, errTokenTimeout):
+		atomic.AddInt32(&w.tokenTimeouts, 1)
+	case errors.Is(err, errGenerationTimeout):
+		atomic.AddInt32(&w.generationTimeouts, 1)
+	}
 }
 
-func (w *Worker) openaiFilter(data string) (string, error) {
-	data = strings.TrimPrefix(data, "data: ")
-
-	if string(data) == "[DONE]" {
-		return "", io.EOF
+func (w *Worker) countUsage(usage *BackendUsage) {
+	if usage == nil {
+		return
 	}
+	atomic.AddInt64(&w.promptTokens, int64(usage.PromptTokens))
+	atomic.AddInt64(&w.completionTokens, int64(usage.CompletionTokens))
+	atomic.AddInt64(&w.loadTime, usage.LoadDuration.Milliseconds())
+	atomic.AddInt64(&w.promptEvalTime, usage.PromptDuration.Milliseconds())
+	atomic.AddInt64(&w.evalTime, usage.EvalDuration.Milliseconds())
+}
 
-	var jsn openaiResponse
-	if err := json.Unmarshal([]byte(data), &jsn); err != nil {
-		return "", err
-	}
+func (w *Worker) calcAvgReqTime(reqTime int64) {
+	reqTime = time.Now().UnixMilli() - reqTime
+	atomic.AddInt64(&w.totalReqTime, reqTime)
 
-	if len(jsn.Choices) > 0 {
-		return jsn.Choices[0].Delta.Content, nil
-	}
+	totalReqTime := atomic.LoadInt64(&w.totalReqTime)
+	numRequests := atomic.LoadInt32(&w.finished)
 
-	return "", nil
+	atomic.StoreInt64(&w.avgReqTime, totalReqTime/int64(numRequests))
 }
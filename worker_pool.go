@@ -1,90 +1,293 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"math/rand"
 	"strconv"
+	"sync"
 
 	"github.com/rs/zerolog/log"
 )
 
-type (
-	WorkerPoolConfig []WorkerConfig
-	WorkerPoolStats  []WorkerStats
+type WorkerPoolStats []WorkerStats
+
+type WorkerPoolConfig struct {
+	Workers   []WorkerConfig      `json:"workers"`
+	Strategy  string              `json:"strategy,omitempty"`
+	Affinity  AffinityConfig      `json:"affinity,omitempty"`
+	Failover  FailoverConfig      `json:"failover,omitempty"`
+	Hedge     HedgeConfig         `json:"hedge,omitempty"`
+	Groups    []WorkerGroupConfig `json:"groups,omitempty"`
+	Allocator AllocatorConfig     `json:"allocator,omitempty"`
+	Priority  PriorityConfig      `json:"priority,omitempty"`
+	Fair      FairConfig          `json:"fair,omitempty"`
+	Admission AdmissionConfig     `json:"admission,omitempty"`
+}
+
+var (
+	errUnknownModel  = errors.New("model is not served by any worker")
+	errUnknownWorker = errors.New("unknown worker")
+	errWorkerExists  = errors.New("worker already exists")
+	errNoLiveWorker  = errors.New("could not connect to live LLM server")
 )
 
 type WorkerPool struct {
+	mu      sync.RWMutex
 	workers []*Worker
+	nextId  int
+	running bool
+
+	balancer       Balancer
+	failoverConfig FailoverConfig
+	hedgeConfig    HedgeConfig
+	groups         []WorkerGroupConfig
+	allocator      *Allocator
+	pending        *pendingQueue
+	priority       *Priority
+	admission      AdmissionConfig
+	throughput     throughput
 }
 
-func NewWorkerPool(config WorkerPoolConfig) WorkerPool {
-	workers := make([]*Worker, len(config))
-	for i, wc := range config {
-		workers[i] = NewWorker(wc)
+func NewWorkerPool(config WorkerPoolConfig) *WorkerPool {
+	config.Failover.defaults()
+
+	balancer, err := NewBalancer(config)
+	if err != nil {
+		panic(err)
 	}
-	return WorkerPool{
-		workers: workers,
+
+	priority, err := NewPriority(config.Priority)
+	if err != nil {
+		panic(err)
+	}
+
+	wp := &WorkerPool{
+		balancer:       balancer,
+		failoverConfig: config.Failover,
+		hedgeConfig:    config.Hedge,
+		groups:         config.Groups,
+		allocator:      NewAllocator(config.Allocator),
+		pending:        newPendingQueue(config.Fair),
+		priority:       priority,
+		admission:      config.Admission,
+	}
+
+	if len(wp.groups) == 0 {
+		// Without groups every worker belongs to one unnamed group.
+		wp.groups = []WorkerGroupConfig{{}}
 	}
+
+	for _, wc := range config.Workers {
+		if _, err := wp.Add(wc); err != nil {
+			panic(err)
+		}
+	}
+
+	return wp
 }
 
 func (wp *WorkerPool) Run() {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	wp.running = true
 	for _, worker := range wp.workers {
 		go worker.Work()
 	}
+	go wp.dispatcher()
 }
 
-func (wp *WorkerPool) Enlist(job *Job) error {
-	worker := wp.getWorker()
+// Add registers a new worker, starting it if the pool is already running.
+// Workers without an id are numbered in the order they are added.
+func (wp *WorkerPool) Add(config WorkerConfig) (*Worker, error) {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+
+	if config.Id == "" {
+		for wp.find(strconv.Itoa(wp.nextId)) != nil {
+			wp.nextId++
+		}
+		config.Id = strconv.Itoa(wp.nextId)
+		wp.nextId++
+	} else if wp.find(config.Id) != nil {
+		return nil, fmt.Errorf("%w: %s", errWorkerExists, config.Id)
+	}
+
+	if !wp.hasGroup(config.Group) {
+		return nil, fmt.Errorf("%w: %q", errUnknownGroup, config.Group)
+	}
+
+	worker, err := newWorker(config)
+	if err != nil {
+		return nil, err
+	}
+	worker.onRelease = wp.notify
+
+	wp.workers = append(wp.workers, worker)
+	if wp.running {
+		go worker.Work()
+	}
+
+	log.Info().Str("worker id", worker.id).Str("worker host", worker.host).Msg("Added worker to pool")
+	return worker, nil
+}
+
+type WorkerUpdate struct {
+	Capacity *int              `json:"capacity,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+}
+
+func (wp *WorkerPool) Update(id string, update WorkerUpdate) error {
+	worker := wp.Get(id)
 	if worker == nil {
-		return fmt.Errorf("could not connect to live LLM server")
+		return fmt.Errorf("%w: %s", errUnknownWorker, id)
 	}
-	select {
-	case <-job.ReqCtx.Done():
-		return nil
-	case worker.Jobs <- job:
-		log.
-			Info().
-			Str("request id", job.Id).
-			Str("worker host", worker.host).
-			Msg("Allocating job to worker")
-		return nil
+
+	if update.Capacity != nil {
+		if *update.Capacity <= 0 {
+			return fmt.Errorf("worker capacity must be positive")
+		}
+		worker.Resize(*update.Capacity)
+	}
+
+	if update.Headers != nil {
+		worker.SetHeaders(update.Headers)
 	}
+
+	log.Info().Str("worker id", id).Msg("Updated worker")
+	return nil
 }
 
-func (wp *WorkerPool) Revive(num int) {
-	if num >= len(wp.workers) {
-		return
+// Remove stops a worker and takes it out of the pool. Jobs it is already
+// running are left to finish.
+func (wp *WorkerPool) Remove(id string) error {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+
+	for i, worker := range wp.workers {
+		if worker.id == id {
+			wp.workers = append(wp.workers[:i:i], wp.workers[i+1:]...)
+			worker.Stop()
+			log.Info().Str("worker id", id).Str("worker host", worker.host).Msg("Removed worker from pool")
+			return nil
+		}
 	}
-	wp.workers[num].Revive()
+
+	return fmt.Errorf("%w: %s", errUnknownWorker, id)
 }
 
-func (wp *WorkerPool) Stats() WorkerPoolStats {
-	stats := make(WorkerPoolStats, len(wp.workers))
-	for i, w := range wp.workers {
-		stats[i] = w.Stats()
-		stats[i].Host = strconv.Itoa(i)
+func (wp *WorkerPool) Get(id string) *Worker {
+	wp.mu.RLock()
+	defer wp.mu.RUnlock()
+	return wp.find(id)
+}
+
+// Workers returns a snapshot of the pool's workers.
+func (wp *WorkerPool) Workers() []*Worker {
+	wp.mu.RLock()
+	defer wp.mu.RUnlock()
+	return wp.workers
+}
+
+func (wp *WorkerPool) find(id string) *Worker {
+	for _, worker := range wp.workers {
+		if worker.id == id {
+			return worker
+		}
 	}
-	return stats
+	return nil
 }
 
-func (wp *WorkerPool) getWorker() *Worker {
-	if len(wp.workers) == 0 {
-		return nil
+func (wp *WorkerPool) Enlist(job *Job) error {
+	if !wp.Serves(job.Model) {
+		return fmt.Errorf("%w: %s", errUnknownModel, job.Model)
 	}
+	if job.Tokens == 0 {
+		job.Tokens = wp.allocator.Estimate(job)
+	}
+	if !wp.fits(job) {
+		return fmt.Errorf("%w: about %d tokens", errPromptTooLarge, job.Tokens)
+	}
+	if !wp.live(wp.Workers(), job) {
+		return errNoLiveWorker
+	}
+	job.onFail = wp.failover
+	job.onDrain = wp.handBack
+	job.priority = wp.priority.Class(job)
+	return wp.enqueue(job)
+}
 
-	var freeWorker *Worker
-	var minLoad float64
+func (wp *WorkerPool) assign(job *Job, worker *Worker) bool {
+	job.attempt++
+	if !worker.Submit(job) {
+		job.attempt--
+		return false
+	}
+	log.
+		Info().
+		Str("request id", job.Id).
+		Str("worker host", worker.host).
+		Int("attempt", job.attempt).
+		Msg("Allocating job to worker")
+	return true
+}
 
-	perm := rand.Perm(len(wp.workers))
-	for i, v := range perm {
-		worker := wp.workers[v]
-		load := worker.Load()
+func (wp *WorkerPool) Serves(model string) bool {
+	for _, worker := range wp.Workers() {
+		if worker.Serves(model) {
+			return true
+		}
+	}
+	return false
+}
 
-		if (i == 0 || load < minLoad) && worker.Alive {
-			freeWorker = worker
-			minLoad = load
+// fits reports whether the job is small enough for the context window of
+// any worker for its model and selector, whatever that worker's state.
+func (wp *WorkerPool) fits(job *Job) bool {
+	matched := false
+	for _, worker := range wp.Workers() {
+		if !worker.Serves(job.Model) || !worker.Matches(job.Selector) {
+			continue
 		}
+		if wp.allocator.Fits(worker, job) {
+			return true
+		}
+		matched = true
+	}
+	return !matched
+}
+
+// eligible reports whether worker may ever run job.
+func (wp *WorkerPool) eligible(worker *Worker, job *Job) bool {
+	return worker.Serves(job.Model) && worker.Matches(job.Selector) && wp.allocator.Fits(worker, job)
+}
+
+func (wp *WorkerPool) Revive(id string) error {
+	worker := wp.Get(id)
+	if worker == nil {
+		return fmt.Errorf("%w: %s", errUnknownWorker, id)
+	}
+	worker.Revive()
+	return nil
+}
+
+// Drain takes a worker out of rotation, see Worker.Drain.
+func (wp *WorkerPool) Drain(id string) (<-chan struct{}, error) {
+	worker := wp.Get(id)
+	if worker == nil {
+		return nil, fmt.Errorf("%w: %s", errUnknownWorker, id)
 	}
+	return worker.Drain(), nil
+}
+
+func (wp *WorkerPool) Stats() WorkerPoolStats {
+	workers := wp.Workers()
+	stats := make(WorkerPoolStats, len(workers))
+	for i, w := range workers {
+		stats[i] = w.Stats()
+	}
+	return stats
+}
 
-	return freeWorker
+func (wp *WorkerPool) getWorker(job *Job) *Worker {
+	return wp.pickWorker(wp.Workers(), job, 0, nil)
 }
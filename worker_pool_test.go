@@ -2,8 +2,16 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestWorkerPool(t *testing.T) {
@@ -17,8 +25,10 @@ func TestWorkerPool(t *testing.T) {
 	fmt.Printf("host 1: %s host 2: %s\n", simWorker1.URL, simWorker2.URL)
 
 	workerPool := NewWorkerPool(WorkerPoolConfig{
-		WorkerConfig{Host: simWorker1.URL, Capacity: 2},
-		WorkerConfig{Host: simWorker2.URL, Capacity: 1},
+		Workers: []WorkerConfig{
+			{Host: simWorker1.URL, Capacity: 2},
+			{Host: simWorker2.URL, Capacity: 1},
+		},
 	})
 	go workerPool.Run()
 
@@ -48,3 +58,551 @@ func TestWorkerPool(t *testing.T) {
 		}
 	}
 }
+
+func simulateFailingWorker(status int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/generate" {
+			w.WriteHeader(status)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+func collectJob(t *testing.T, job *Job) (string, error) {
+	var out strings.Builder
+	for {
+		select {
+		case token := <-job.Output:
+			out.WriteString(token)
+		case err := <-job.Err:
+			return out.String(), err
+		case <-job.Ctx.Done():
+			for len(job.Output) > 0 {
+				out.WriteString(<-job.Output)
+			}
+			return out.String(), nil
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for job")
+		}
+	}
+}
+
+func TestWorkerPoolFailover(t *testing.T) {
+	gate := make(chan struct{})
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-gate
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	healthy := simulateWorker()
+	defer failing.Close()
+	defer healthy.Close()
+
+	workerPool := NewWorkerPool(WorkerPoolConfig{
+		Workers: []WorkerConfig{
+			{Host: failing.URL, Capacity: 1},
+			{Host: healthy.URL, Capacity: 1},
+		},
+		Failover: FailoverConfig{Attempts: 2},
+	})
+	go workerPool.Run()
+
+	// Force the first attempt onto the failing worker.
+	workerPool.workers[1].setAlive(false)
+	job := NewJob(context.Background(), "1", nil)
+	if err := workerPool.Enlist(job); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, func() bool { return workerPool.workers[0].Outstanding() == 1 })
+	workerPool.workers[1].setAlive(true)
+	close(gate)
+
+	out, err := collectJob(t, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out, "abc") {
+		t.Fatalf("expected output from the healthy worker, got %q", out)
+	}
+	if job.attempt != 2 {
+		t.Fatalf("expected 2 attempts, got %d", job.attempt)
+	}
+	if workerPool.workers[0].Stats().Fails != 1 {
+		t.Fatalf("expected the failing worker to record a failure")
+	}
+}
+
+func TestWorkerPoolFailoverExhausted(t *testing.T) {
+	failing1 := simulateFailingWorker(http.StatusServiceUnavailable)
+	failing2 := simulateFailingWorker(http.StatusServiceUnavailable)
+	rejecting := simulateFailingWorker(http.StatusBadRequest)
+	defer failing1.Close()
+	defer failing2.Close()
+	defer rejecting.Close()
+
+	workerPool := NewWorkerPool(WorkerPoolConfig{
+		Workers: []WorkerConfig{
+			{Host: failing1.URL, Capacity: 1},
+			{Host: failing2.URL, Capacity: 1},
+		},
+	})
+	go workerPool.Run()
+
+	job := NewJob(context.Background(), "1", nil)
+	workerPool.Enlist(job)
+	if _, err := collectJob(t, job); err == nil {
+		t.Fatal("expected an error once every worker has failed")
+	}
+	if job.attempt != 2 {
+		t.Fatalf("expected 2 attempts, got %d", job.attempt)
+	}
+
+	rejectPool := NewWorkerPool(WorkerPoolConfig{
+		Workers: []WorkerConfig{
+			{Host: rejecting.URL, Capacity: 1},
+			{Host: failing1.URL, Capacity: 1},
+		},
+	})
+	go rejectPool.Run()
+
+	job = NewJob(context.Background(), "2", nil)
+	job.tried[rejectPool.workers[1]] = true
+	rejectPool.Enlist(job)
+	if _, err := collectJob(t, job); err == nil {
+		t.Fatal("expected client errors to be returned without failover")
+	}
+	if job.attempt != 1 {
+		t.Fatalf("expected 1 attempt, got %d", job.attempt)
+	}
+}
+
+func TestWorkerPoolModelRouting(t *testing.T) {
+	sim1 := simulateWorker()
+	sim2 := simulateWorker()
+	defer sim1.Close()
+	defer sim2.Close()
+
+	workerPool := NewWorkerPool(WorkerPoolConfig{
+		Workers: []WorkerConfig{
+			{Host: sim1.URL, Capacity: 100, Models: []string{"llama2"}},
+			{Host: sim2.URL, Capacity: 1, Models: []string{"mistral", "mixtral"}},
+		},
+	})
+	go workerPool.Run()
+
+	for i := 0; i < 5; i++ {
+		job := NewJob(context.Background(), fmt.Sprint(i), nil)
+		job.Model = "mixtral"
+		if err := workerPool.Enlist(job); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := collectJob(t, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if workerPool.workers[0].Stats().Requests != 0 || workerPool.workers[1].Stats().Requests != 5 {
+		t.Fatalf("expected every job on the mixtral worker, got %+v", workerPool.Stats())
+	}
+
+	job := NewJob(context.Background(), "unknown", nil)
+	job.Model = "gpt-4"
+	if err := workerPool.Enlist(job); !errors.Is(err, errUnknownModel) {
+		t.Fatalf("expected unknown model error, got %v", err)
+	}
+
+	workerPool.workers[1].setAlive(false)
+	job = NewJob(context.Background(), "dead", nil)
+	job.Model = "mistral"
+	if err := workerPool.Enlist(job); err == nil || errors.Is(err, errUnknownModel) {
+		t.Fatalf("expected no live worker error, got %v", err)
+	}
+}
+
+func TestGetModel(t *testing.T) {
+	r := httptest.NewRequest("POST", "/generate", nil)
+	if model := getModel(r, []byte(`{"model":"llama2","prompt":"hi"}`)); model != "llama2" {
+		t.Fatalf("expected model from payload, got %q", model)
+	}
+
+	r.Header.Set("X-Model", "mistral")
+	if model := getModel(r, []byte(`{"model":"llama2"}`)); model != "mistral" {
+		t.Fatalf("expected model from header, got %q", model)
+	}
+
+	if model := getModel(httptest.NewRequest("POST", "/generate", nil), []byte("raw prompt")); model != "" {
+		t.Fatalf("expected no model for a raw payload, got %q", model)
+	}
+}
+
+func TestWorkerPoolDrain(t *testing.T) {
+	release := make(chan struct{})
+	blocking := simulateBlockingWorker(release)
+	defer blocking.Close()
+	healthy := simulateWorker()
+	defer healthy.Close()
+
+	workerPool := NewWorkerPool(WorkerPoolConfig{
+		Workers: []WorkerConfig{
+			{Id: "draining", Host: blocking.URL, Capacity: 1, FirstTokenTimeout: -1},
+			{Id: "healthy", Host: healthy.URL, Capacity: 1},
+		},
+		Failover: FailoverConfig{Attempts: 1},
+	})
+	workerPool.Run()
+	draining := workerPool.Get("draining")
+
+	running := NewJob(context.Background(), "running", nil)
+	running.onFail = workerPool.failover
+	workerPool.assign(running, draining)
+	waitFor(t, 5*time.Second, func() bool { return draining.Stats().Running == 1 })
+
+	// Neither the failover attempts nor its deadline apply to hand-offs.
+	queued := NewJob(context.Background(), "queued", nil)
+	queued.start = time.Now().Add(-time.Minute)
+	queued.onFail = workerPool.failover
+	queued.onDrain = workerPool.handBack
+	workerPool.assign(queued, draining)
+	waitFor(t, 5*time.Second, func() bool { return draining.Stats().Queued == 1 })
+
+	idle, err := workerPool.Drain("draining")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out, err := collectJob(t, queued); err != nil || out == "" {
+		t.Fatalf("expected queued job to move to the healthy worker, got %q, %v", out, err)
+	}
+	if !queued.hasTried(draining) {
+		t.Fatal("expected queued job to be handed off")
+	}
+	workerPool.pending.mu.Lock()
+	attempt := queued.attempt
+	workerPool.pending.mu.Unlock()
+	if attempt != 1 {
+		t.Fatalf("expected the hand-off not to count as an attempt, got %d", attempt)
+	}
+
+	for i := 0; i < 10; i++ {
+		if worker := workerPool.getWorker(nil); worker == draining {
+			t.Fatal("draining worker was assigned a new job")
+		}
+	}
+
+	select {
+	case <-idle:
+		t.Fatal("worker reported idle while a job was still running")
+	default:
+	}
+	if stats := draining.Stats(); !stats.Draining || stats.Drained {
+		t.Fatalf("unexpected drain state %+v", stats)
+	}
+
+	close(release)
+	collectJob(t, running)
+
+	select {
+	case <-idle:
+	case <-time.After(5 * time.Second):
+		t.Fatal("worker never reported idle")
+	}
+	if !draining.Stats().Drained {
+		t.Fatal("expected worker to report drained")
+	}
+
+	if err := workerPool.Revive("draining"); err != nil {
+		t.Fatal(err)
+	}
+	if draining.Draining() || !draining.Available() {
+		t.Fatal("expected revived worker to be back in service")
+	}
+}
+
+func TestWorkerPoolHedge(t *testing.T) {
+	cancelled := make(chan struct{})
+	var once sync.Once
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(500 * time.Millisecond):
+		case <-r.Context().Done():
+			once.Do(func() { close(cancelled) })
+		}
+	}))
+	defer slow.Close()
+	fast := simulateWorker()
+	defer fast.Close()
+
+	workerPool := NewWorkerPool(WorkerPoolConfig{
+		Workers: []WorkerConfig{
+			{Id: "slow", Host: slow.URL, Capacity: 1},
+			{Id: "fast", Host: fast.URL, Capacity: 1},
+		},
+		Strategy: balancerRoundRobin,
+		Hedge:    HedgeConfig{Delay: 50, Header: "X-Hedge"},
+	})
+	go workerPool.Run()
+
+	job := NewJob(context.Background(), "hedged", nil)
+	job.Header = http.Header{"X-Hedge": {"true"}}
+	if err := workerPool.Enlist(job); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := collectJob(t, job); err != nil || !strings.HasPrefix(out, "abcdefghijklmnopqrstuvwxyz") {
+		t.Fatalf("expected the hedge to stream the output, got %q, %v", out, err)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the losing request to be cancelled")
+	}
+
+	stats := workerPool.Get("fast").Stats()
+	if stats.HedgesIssued != 1 || stats.HedgesWon != 1 {
+		t.Fatalf("expected 1 hedge issued and won, got %d/%d", stats.HedgesIssued, stats.HedgesWon)
+	}
+	waitFor(t, 5*time.Second, func() bool { return workerPool.Get("slow").Outstanding() == 0 })
+	if stats := workerPool.Get("slow").Stats(); stats.Fails != 0 {
+		t.Fatalf("expected the loser not to count as a failure, got %d", stats.Fails)
+	}
+
+	// The slow worker answers well after the hedge delay, so a hedge would
+	// have been issued by the time the job is done.
+	unhedged := NewJob(context.Background(), "unhedged", nil)
+	if err := workerPool.Enlist(unhedged); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, func() bool { return unhedged.Ctx.Err() != nil })
+	issued := 0
+	for _, stats := range workerPool.Stats() {
+		issued += stats.HedgesIssued
+	}
+	if issued != 1 {
+		t.Fatal("expected jobs without the header not to be hedged")
+	}
+}
+
+func TestWorkerPoolHedgeQueued(t *testing.T) {
+	var mu sync.Mutex
+	var order []string
+	release := map[string]chan struct{}{"hedged": make(chan struct{}), "b": make(chan struct{}), "c": make(chan struct{})}
+	defer close(release["hedged"])
+	sim := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		order = append(order, string(body))
+		mu.Unlock()
+		select {
+		case <-release[string(body)]:
+		case <-r.Context().Done():
+		}
+	}))
+	defer sim.Close()
+
+	workerPool := NewWorkerPool(WorkerPoolConfig{
+		Workers: []WorkerConfig{
+			{Host: sim.URL, Capacity: 1, FirstTokenTimeout: -1},
+			{Host: sim.URL, Capacity: 1, FirstTokenTimeout: -1},
+		},
+		Hedge: HedgeConfig{Delay: 50, Header: "X-Hedge"},
+	})
+	go workerPool.Run()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	requests := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(order)
+	}
+	enlist := func(id string, header http.Header) {
+		job := NewJob(ctx, id, []byte(id))
+		job.Header = header
+		if err := workerPool.Enlist(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hedgesIssued := func() int {
+		issued := 0
+		for _, stats := range workerPool.Stats() {
+			issued += stats.HedgesIssued
+		}
+		return issued
+	}
+
+	enlist("hedged", http.Header{"X-Hedge": {"true"}})
+	enlist("b", nil)
+	waitFor(t, 5*time.Second, func() bool { return requests() == 2 })
+	enlist("c", nil)
+	waitFor(t, 5*time.Second, func() bool {
+		workerPool.pending.mu.Lock()
+		defer workerPool.pending.mu.Unlock()
+		return len(workerPool.pending.hedges) == 1
+	})
+
+	// The slot freed by b goes to the queued job c, not to the hedge.
+	close(release["b"])
+	waitFor(t, 5*time.Second, func() bool { return workerPool.Pending() == 0 })
+	if issued := hedgesIssued(); issued != 0 {
+		t.Fatalf("expected the hedge to wait behind the queued job, got %d issued", issued)
+	}
+
+	// Once nothing is queued the hedge may have the slot.
+	close(release["c"])
+	waitFor(t, 5*time.Second, func() bool { return hedgesIssued() == 1 })
+
+	mu.Lock()
+	defer mu.Unlock()
+	if expected := []string{"c", "hedged"}; len(order) != 4 || !reflect.DeepEqual(order[2:], expected) {
+		t.Fatalf("expected requests in order %v, got %v", expected, order)
+	}
+}
+
+func TestWorkerPoolHedgeFailure(t *testing.T) {
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(150 * time.Millisecond)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+	slow := simulateSlowWorker(500*time.Millisecond, 0)
+	defer slow.Close()
+
+	workerPool := NewWorkerPool(WorkerPoolConfig{
+		Workers: []WorkerConfig{
+			{Id: "broken", Host: broken.URL, Capacity: 1},
+			{Id: "slow", Host: slow.URL, Capacity: 1},
+		},
+		Strategy: balancerRoundRobin,
+		Hedge:    HedgeConfig{Delay: 50},
+	})
+	go workerPool.Run()
+
+	// The first attempt fails while its hedge is still waiting for a token.
+	job := NewJob(context.Background(), "hedged", nil)
+	if err := workerPool.Enlist(job); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := collectJob(t, job); err != nil || !strings.HasPrefix(out, "abc") {
+		t.Fatalf("expected the hedge to stream the output, got %q, %v", out, err)
+	}
+
+	waitFor(t, 5*time.Second, func() bool { return workerPool.Get("broken").Outstanding() == 0 })
+	if stats := workerPool.Get("broken").Stats(); stats.Fails != 1 {
+		t.Fatalf("expected the failed attempt to count against its worker, got %d fails", stats.Fails)
+	}
+}
+
+func TestWorkerPoolGroups(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	local := simulateBlockingWorker(release)
+	defer local.Close()
+	hosted := simulateWorker()
+	defer hosted.Close()
+
+	sf := New(StarFleetConfig{
+		WorkerPoolConfig: WorkerPoolConfig{
+			Workers: []WorkerConfig{
+				{Id: "local", Group: "local", Host: local.URL, Capacity: 1, FirstTokenTimeout: -1},
+				{Id: "hosted", Group: "hosted", Host: hosted.URL, Capacity: 1},
+			},
+			Groups: []WorkerGroupConfig{
+				{Name: "local", MaxQueueDepth: 1},
+				{Name: "hosted"},
+			},
+		},
+	})
+	workerPool := sf.workerPool
+	go workerPool.Run()
+
+	if _, err := workerPool.Add(WorkerConfig{Host: local.URL, Capacity: 1, Group: "nope"}); !errors.Is(err, errUnknownGroup) {
+		t.Fatalf("expected unknown group error, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	localWorker := workerPool.Get("local")
+	if worker := workerPool.getWorker(nil); worker != localWorker {
+		t.Fatal("expected the primary group to be used first")
+	}
+	workerPool.Enlist(NewJob(ctx, "running", nil))
+	waitFor(t, 5*time.Second, func() bool { return localWorker.Stats().Running == 1 })
+
+	if worker := workerPool.getWorker(nil); worker != nil {
+		t.Fatal("expected jobs to wait for the primary group below its depth")
+	}
+	queued := NewJob(ctx, "queued", nil)
+	workerPool.Enlist(queued)
+	time.Sleep(2 * poolDispatchInterval)
+	if position, ok := workerPool.Position("queued"); !ok || position != 0 {
+		t.Fatalf("expected the job to wait in the pool queue, got %d, %v", position, ok)
+	}
+
+	overflow := NewJob(ctx, "overflow", nil)
+	workerPool.Enlist(overflow)
+	if _, err := collectJob(t, overflow); err != nil {
+		t.Fatal(err)
+	}
+	if winner := overflow.Winner(); winner == nil || winner.group != "hosted" {
+		t.Fatal("expected overflow once the primary group is at its depth")
+	}
+
+	if (WorkerGroupConfig{MaxWait: 50}).holds(0, 100*time.Millisecond) {
+		t.Fatal("expected jobs to overflow after the maximum wait")
+	}
+
+	localWorker.Drain()
+	if _, err := collectJob(t, queued); err != nil || queued.Winner().group != "hosted" {
+		t.Fatalf("expected the queued job to overflow once the primary group is drained, got %v", err)
+	}
+
+	r := httptest.NewRequest("POST", "/generate", strings.NewReader("prompt"))
+	w := httptest.NewRecorder()
+	sf.handleGenerate(w, r)
+	if group := w.Header().Get("X-Worker-Group"); group != "hosted" {
+		t.Fatalf("expected the response to report the hosted group, got %q", group)
+	}
+	if !strings.HasPrefix(w.Body.String(), "abcdefghijklmnopqrstuvwxyz") {
+		t.Fatalf("unexpected output %q", w.Body.String())
+	}
+}
+
+func TestWorkerPoolSelector(t *testing.T) {
+	sim := simulateWorker()
+	defer sim.Close()
+
+	workerPool := NewWorkerPool(WorkerPoolConfig{
+		Workers: []WorkerConfig{
+			{Id: "shared", Host: sim.URL, Capacity: 1},
+			{Id: "acme", Host: sim.URL, Capacity: 1, Labels: map[string]string{"tenant": "acme", "tier": "gpu-a100"}, Dedicated: true},
+		},
+	})
+
+	job := NewJob(context.Background(), "acme", nil)
+	job.Selector = map[string]string{"tenant": "acme"}
+	for i := 0; i < 10; i++ {
+		if worker := workerPool.getWorker(job); worker == nil || worker.id != "acme" {
+			t.Fatalf("expected the dedicated worker, got %v", worker)
+		}
+	}
+
+	job.Selector = map[string]string{"tenant": "acme", "tier": "gpu-h100"}
+	if err := workerPool.Enlist(job); err == nil {
+		t.Fatal("expected no worker to match the selector")
+	}
+
+	for i := 0; i < 10; i++ {
+		if worker := workerPool.getWorker(NewJob(context.Background(), "any", nil)); worker == nil || worker.id != "shared" {
+			t.Fatalf("expected jobs without a selector to stay off the dedicated worker, got %v", worker)
+		}
+	}
+
+	// With the shared worker gone, unselected jobs have nowhere to run.
+	if err := workerPool.Remove("shared"); err != nil {
+		t.Fatal(err)
+	}
+	if err := workerPool.Enlist(NewJob(context.Background(), "any", nil)); !errors.Is(err, errNoLiveWorker) {
+		t.Fatalf("expected no live worker for an unselected job, got %v", err)
+	}
+}
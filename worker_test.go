@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func simulateWorker() *httptest.Server {
@@ -45,3 +48,153 @@ func TestCharIter(t *testing.T) {
 		fmt.Println(string(i))
 	}
 }
+
+func simulateSlowWorker(firstToken time.Duration, gap time.Duration) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher := w.(http.Flusher)
+		select {
+		case <-time.After(firstToken):
+		case <-r.Context().Done():
+			return
+		}
+		for i := 'a'; i <= 'z'; i++ {
+			fmt.Fprint(w, string(i))
+			flusher.Flush()
+			select {
+			case <-time.After(gap):
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}))
+}
+
+func TestWorkerDeadlines(t *testing.T) {
+	tests := []struct {
+		name       string
+		firstToken time.Duration
+		gap        time.Duration
+		config     WorkerConfig
+		err        error
+		counter    func(WorkerStats) int
+	}{
+		{
+			name:       "first token",
+			firstToken: 2 * time.Second,
+			config:     WorkerConfig{FirstTokenTimeout: 1},
+			err:        errFirstTokenTimeout,
+			counter:    func(s WorkerStats) int { return s.FirstTokenTimeouts },
+		},
+		{
+			name:    "stall",
+			gap:     2 * time.Second,
+			config:  WorkerConfig{TokenTimeout: 1},
+			err:     errTokenTimeout,
+			counter: func(s WorkerStats) int { return s.TokenTimeouts },
+		},
+		{
+			name:    "generation",
+			gap:     100 * time.Millisecond,
+			config:  WorkerConfig{GenerationTimeout: 1},
+			err:     errGenerationTimeout,
+			counter: func(s WorkerStats) int { return s.GenerationTimeouts },
+		},
+	}
+
+	for _, test := range tests {
+		sim := simulateSlowWorker(test.firstToken, test.gap)
+		defer sim.Close()
+
+		test.config.Host = sim.URL
+		test.config.Capacity = 1
+		worker := NewWorker(test.config)
+		go worker.Work()
+
+		job := NewJob(context.Background(), test.name, nil)
+		worker.Submit(job)
+
+		var err error
+		select {
+		case err = <-job.Err:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: timed out waiting for the deadline", test.name)
+		}
+
+		if !errors.Is(err, test.err) {
+			t.Fatalf("%s: expected %v, got %v", test.name, test.err, err)
+		}
+
+		<-job.Ctx.Done()
+		waitFor(t, time.Second, func() bool { return test.counter(worker.Stats()) == 1 && worker.Stats().Fails == 1 })
+	}
+}
+
+func TestWorkerHeartbeatDoesNotBlock(t *testing.T) {
+	probing := make(chan struct{}, 1)
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case probing <- struct{}{}:
+		default:
+		}
+		<-r.Context().Done()
+	}))
+	defer hung.Close()
+
+	worker := NewWorker(WorkerConfig{Host: hung.URL, Capacity: 1, CheckAlive: true, Heartbeat: 1, Health: HealthConfig{Timeout: 3}})
+	defer worker.Stop()
+	go worker.Work()
+
+	select {
+	case <-probing:
+	case <-time.After(5 * time.Second):
+		t.Fatal("heartbeat never probed the worker")
+	}
+
+	start := time.Now()
+	worker.Available()
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("expected liveness to be read without waiting for the probe, took %v", elapsed)
+	}
+}
+
+func TestWorkerClientCancel(t *testing.T) {
+	simA := simulateSlowWorker(time.Second, 0)
+	defer simA.Close()
+	simB := simulateSlowWorker(time.Second, 0)
+	defer simB.Close()
+
+	workerPool := NewWorkerPool(WorkerPoolConfig{
+		Workers: []WorkerConfig{
+			{Host: simA.URL, Capacity: 3, Breaker: BreakerConfig{MinRequests: 1}},
+			{Host: simB.URL, Capacity: 3, Breaker: BreakerConfig{MinRequests: 1}},
+		},
+	})
+	go workerPool.Run()
+
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		if err := workerPool.Enlist(NewJob(ctx, fmt.Sprint(i), nil)); err != nil {
+			t.Fatal(err)
+		}
+		time.AfterFunc(200*time.Millisecond, cancel)
+	}
+
+	waitFor(t, 5*time.Second, func() bool {
+		finished := 0
+		for _, stats := range workerPool.Stats() {
+			finished += stats.Finished
+		}
+		return finished == 3
+	})
+
+	requests := 0
+	for _, stats := range workerPool.Stats() {
+		requests += stats.Requests
+		if stats.Fails != 0 || stats.Breaker != BreakerClosed.String() {
+			t.Fatalf("expected cancelled requests not to count against the worker, got %+v", stats)
+		}
+	}
+	if requests != 3 {
+		t.Fatalf("expected cancelled requests not to be retried, got %d requests", requests)
+	}
+}